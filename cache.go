@@ -2,8 +2,16 @@
 package multikeycache
 
 import (
+	"cmp"
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // To avoid confusing myself with the generic types, I'm using the following naming conventions:
@@ -12,6 +20,26 @@ import (
 // - SKNT: (SecondaryKeyNameType) The type of the secondary key name
 // - SKT: (SecondaryKeyType) The type of the secondary key
 
+// The kinds of the errors returned by the cache, which match the errors of the corresponding type
+// with errors.Is regardless of its type parameters, e.g. errors.Is(err, ErrUnknownSecondaryKeyKind)
+var (
+	ErrSecondaryKeyNumberMismatchKind = errors.New("number of secondary keys does not match number of secondary key names")
+	ErrTooManySecondaryKeyNamesKind   = errors.New("too many secondary key names")
+	ErrWrongSecondaryKeyKind          = errors.New("secondary key already exists for a different pk")
+	ErrUnknownSecondaryKeyKind        = errors.New("unknown secondary key name")
+	ErrMissingSecondaryKeyKind        = errors.New("missing secondary key")
+	ErrPrimaryKeyExistsKind           = errors.New("pk already exists")
+	ErrPrimaryKeyNotFoundKind         = errors.New("pk not found")
+	ErrZeroSecondaryKeyKind           = errors.New("secondary key is the zero value")
+	ErrSecondaryKeyNameNotUniqueKind  = errors.New("secondary key name is not unique")
+	ErrSecondaryKeyNamesMismatchKind  = errors.New("secondary key names do not match")
+	ErrInconsistentSecondaryKeysKind  = errors.New("secondary keys resolve to different primary keys")
+	ErrInconsistentIndexKind          = errors.New("inconsistent secondary index")
+	ErrOrphanedIndexEntryKind         = errors.New("orphaned secondary index entry")
+	ErrVersionMismatchKind            = errors.New("version mismatch")
+	ErrLoadedKeyMismatchKind          = errors.New("loaded item lacks the requested secondary key")
+)
+
 // ErrSecondaryKeyNumberMismatch is an error that occurs when the number
 // of secondary keys does not match the number of secondary key names during a Set operation
 type ErrSecondaryKeyNumberMismatch struct {
@@ -24,6 +52,28 @@ func (e ErrSecondaryKeyNumberMismatch) Error() string {
 	return fmt.Sprintf("number of secondary keys does not match number of secondary key names: expected %d, actual %d", e.Expected, e.Actual)
 }
 
+// Is reports whether the target is ErrSecondaryKeyNumberMismatchKind, the kind of every error of this type
+func (e ErrSecondaryKeyNumberMismatch) Is(target error) bool {
+	return target == ErrSecondaryKeyNumberMismatchKind
+}
+
+// ErrTooManySecondaryKeyNames is an error that occurs when a cache would get more
+// secondary key names than allowed by WithMaxSecondaryKeyNames
+type ErrTooManySecondaryKeyNames struct {
+	Max    int
+	Actual int
+}
+
+// Error returns a string describing the error
+func (e ErrTooManySecondaryKeyNames) Error() string {
+	return fmt.Sprintf("too many secondary key names: max %d, actual %d", e.Max, e.Actual)
+}
+
+// Is reports whether the target is ErrTooManySecondaryKeyNamesKind, the kind of every error of this type
+func (e ErrTooManySecondaryKeyNames) Is(target error) bool {
+	return target == ErrTooManySecondaryKeyNamesKind
+}
+
 // ErrWrongSecondaryKey is an error that occurs when a secondary key already
 // exists for a different primary key
 type ErrWrongSecondaryKey[PKT comparable, SKNT comparable] struct {
@@ -37,6 +87,11 @@ func (e ErrWrongSecondaryKey[PKT, SKNT]) Error() string {
 	return fmt.Sprintf("secondary key %v already exists for a different pk %v", e.SecondaryKey, e.ExistingPK)
 }
 
+// Is reports whether the target is ErrWrongSecondaryKeyKind, the kind of every error of this type
+func (e ErrWrongSecondaryKey[PKT, SKNT]) Is(target error) bool {
+	return target == ErrWrongSecondaryKeyKind
+}
+
 // ErrUnknownSecondaryKey is an error that occurs when a secondary key name does not exist
 type ErrUnknownSecondaryKey[SKNT comparable] struct {
 	SecondaryKeyName SKNT
@@ -47,6 +102,112 @@ func (e ErrUnknownSecondaryKey[SKNT]) Error() string {
 	return fmt.Sprintf("secondary key not found for secondary key name %v", e.SecondaryKeyName)
 }
 
+// Is reports whether the target is ErrUnknownSecondaryKeyKind, the kind of every error of this type
+func (e ErrUnknownSecondaryKey[SKNT]) Is(target error) bool {
+	return target == ErrUnknownSecondaryKeyKind
+}
+
+// ErrMissingSecondaryKey is an error that occurs when no secondary key is given
+// for one of the secondary key names during a Set operation
+type ErrMissingSecondaryKey[SKNT comparable] struct {
+	SecondaryKeyName SKNT
+}
+
+// Error returns a string describing the error
+func (e ErrMissingSecondaryKey[SKNT]) Error() string {
+	return fmt.Sprintf("missing secondary key for secondary key name %v", e.SecondaryKeyName)
+}
+
+// Is reports whether the target is ErrMissingSecondaryKeyKind, the kind of every error of this type
+func (e ErrMissingSecondaryKey[SKNT]) Is(target error) bool {
+	return target == ErrMissingSecondaryKeyKind
+}
+
+// ErrPrimaryKeyExists is an error that occurs when a Set operation would overwrite
+// an existing item in a cache created with WithNoOverwrite, or when Rekey would move
+// an item onto a primary key already in use
+type ErrPrimaryKeyExists[PKT comparable] struct {
+	PK PKT
+}
+
+// Error returns a string describing the error
+func (e ErrPrimaryKeyExists[PKT]) Error() string {
+	return fmt.Sprintf("pk %v already exists", e.PK)
+}
+
+// Is reports whether the target is ErrPrimaryKeyExistsKind, the kind of every error of this type
+func (e ErrPrimaryKeyExists[PKT]) Is(target error) bool {
+	return target == ErrPrimaryKeyExistsKind
+}
+
+// ErrPrimaryKeyNotFound is an error that occurs when an operation requires
+// an item that is not in the cache
+type ErrPrimaryKeyNotFound[PKT comparable] struct {
+	PK PKT
+}
+
+// Error returns a string describing the error
+func (e ErrPrimaryKeyNotFound[PKT]) Error() string {
+	return fmt.Sprintf("pk %v not found", e.PK)
+}
+
+// Is reports whether the target is ErrPrimaryKeyNotFoundKind, the kind of every error of this type
+func (e ErrPrimaryKeyNotFound[PKT]) Is(target error) bool {
+	return target == ErrPrimaryKeyNotFoundKind
+}
+
+// ErrVersionMismatch is an error that occurs when SetWithVersion expects a different
+// version than the item has
+type ErrVersionMismatch[PKT comparable] struct {
+	PK       PKT
+	Expected uint64
+	Actual   uint64
+}
+
+// Error returns a string describing the error
+func (e ErrVersionMismatch[PKT]) Error() string {
+	return fmt.Sprintf("pk %v has version %d, expected %d", e.PK, e.Actual, e.Expected)
+}
+
+// Is reports whether the target is ErrVersionMismatchKind, the kind of every error of this type
+func (e ErrVersionMismatch[PKT]) Is(target error) bool {
+	return target == ErrVersionMismatchKind
+}
+
+// ErrLoadedKeyMismatch is an error that occurs when the load function of GetOrLoadBySecondaryKey
+// returns an item without the requested secondary key under the requested name
+type ErrLoadedKeyMismatch[PKT comparable, SKNT comparable, SKT comparable] struct {
+	PK               PKT
+	SecondaryKeyName SKNT
+	SecondaryKey     SKT
+}
+
+// Error returns a string describing the error
+func (e ErrLoadedKeyMismatch[PKT, SKNT, SKT]) Error() string {
+	return fmt.Sprintf("loaded pk %v lacks secondary key %v in index %v", e.PK, e.SecondaryKey, e.SecondaryKeyName)
+}
+
+// Is reports whether the target is ErrLoadedKeyMismatchKind, the kind of every error of this type
+func (e ErrLoadedKeyMismatch[PKT, SKNT, SKT]) Is(target error) bool {
+	return target == ErrLoadedKeyMismatchKind
+}
+
+// ErrZeroSecondaryKey is an error that occurs when a secondary key is the zero value
+// of its type and WithRejectZeroSecondaryKeys is used
+type ErrZeroSecondaryKey[SKNT comparable] struct {
+	SecondaryKeyName SKNT
+}
+
+// Error returns a string describing the error
+func (e ErrZeroSecondaryKey[SKNT]) Error() string {
+	return fmt.Sprintf("secondary key %v is the zero value", e.SecondaryKeyName)
+}
+
+// Is reports whether the target is ErrZeroSecondaryKeyKind, the kind of every error of this type
+func (e ErrZeroSecondaryKey[SKNT]) Is(target error) bool {
+	return target == ErrZeroSecondaryKeyKind
+}
+
 // ErrSecondaryKeyNameNotUnique is an error that occurs when a secondary key name is not unique
 type ErrSecondaryKeyNameNotUnique[SKNT comparable] struct {
 	SecondaryKeyName SKNT
@@ -57,11 +218,101 @@ func (e ErrSecondaryKeyNameNotUnique[SKNT]) Error() string {
 	return fmt.Sprintf("secondary key name %v is not unique", e.SecondaryKeyName)
 }
 
+// Is reports whether the target is ErrSecondaryKeyNameNotUniqueKind, the kind of every error of this type
+func (e ErrSecondaryKeyNameNotUnique[SKNT]) Is(target error) bool {
+	return target == ErrSecondaryKeyNameNotUniqueKind
+}
+
+// ErrSecondaryKeyNamesMismatch is an error that occurs when two caches
+// that are combined do not have the same secondary key names in the same order
+type ErrSecondaryKeyNamesMismatch[SKNT comparable] struct {
+	Expected []SKNT
+	Actual   []SKNT
+}
+
+// Error returns a string describing the error
+func (e ErrSecondaryKeyNamesMismatch[SKNT]) Error() string {
+	return fmt.Sprintf("secondary key names do not match: expected %v, actual %v", e.Expected, e.Actual)
+}
+
+// Is reports whether the target is ErrSecondaryKeyNamesMismatchKind, the kind of every error of this type
+func (e ErrSecondaryKeyNamesMismatch[SKNT]) Is(target error) bool {
+	return target == ErrSecondaryKeyNamesMismatchKind
+}
+
+// ErrInconsistentSecondaryKeys is an error that occurs when a set of secondary keys
+// that should identify a single item resolves to different primary keys
+type ErrInconsistentSecondaryKeys[PKT comparable, SKNT comparable] struct {
+	PrimaryKeys map[SKNT]PKT
+}
+
+// Error returns a string describing the error
+func (e ErrInconsistentSecondaryKeys[PKT, SKNT]) Error() string {
+	return fmt.Sprintf("secondary keys resolve to different primary keys: %v", e.PrimaryKeys)
+}
+
+// Is reports whether the target is ErrInconsistentSecondaryKeysKind, the kind of every error of this type
+func (e ErrInconsistentSecondaryKeys[PKT, SKNT]) Is(target error) bool {
+	return target == ErrInconsistentSecondaryKeysKind
+}
+
+// ErrInconsistentIndex is an error that occurs when a secondary index entry
+// does not agree with the secondary keys stored with an item
+type ErrInconsistentIndex[PKT comparable, SKNT comparable, SKT comparable] struct {
+	SecondaryKeyName SKNT
+	SecondaryKey     SKT
+	PK               PKT
+	IndexedPK        PKT
+	Missing          bool
+}
+
+// Error returns a string describing the error
+func (e ErrInconsistentIndex[PKT, SKNT, SKT]) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("secondary key %v of pk %v is missing from index %v", e.SecondaryKey, e.PK, e.SecondaryKeyName)
+	}
+	return fmt.Sprintf("secondary key %v of pk %v points to pk %v in index %v", e.SecondaryKey, e.PK, e.IndexedPK, e.SecondaryKeyName)
+}
+
+// Is reports whether the target is ErrInconsistentIndexKind, the kind of every error of this type
+func (e ErrInconsistentIndex[PKT, SKNT, SKT]) Is(target error) bool {
+	return target == ErrInconsistentIndexKind
+}
+
+// ErrOrphanedIndexEntry is an error that occurs when a secondary index entry points to a pk
+// that is not in the cache, or to an item that does not have the secondary key
+type ErrOrphanedIndexEntry[PKT comparable, SKNT comparable, SKT comparable] struct {
+	SecondaryKeyName SKNT
+	SecondaryKey     SKT
+	IndexedPK        PKT
+	Missing          bool
+}
+
+// Error returns a string describing the error
+func (e ErrOrphanedIndexEntry[PKT, SKNT, SKT]) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("secondary key %v in index %v points to missing pk %v", e.SecondaryKey, e.SecondaryKeyName, e.IndexedPK)
+	}
+	return fmt.Sprintf("secondary key %v in index %v points to pk %v, which does not have it", e.SecondaryKey, e.SecondaryKeyName, e.IndexedPK)
+}
+
+// Is reports whether the target is ErrOrphanedIndexEntryKind, the kind of every error of this type
+func (e ErrOrphanedIndexEntry[PKT, SKNT, SKT]) Is(target error) bool {
+	return target == ErrOrphanedIndexEntryKind
+}
+
 // item is the type of the item stored in the cache
 type item[PKT comparable, VT any, SecondaryKeyNameType comparable, SKT comparable] struct {
 	pk            PKT
 	value         VT
 	secondaryKeys map[SecondaryKeyNameType]SKT
+	elem          *list.Element
+	expiresAt     time.Time
+	cost          int64
+	// reserved is true for an item claimed by Reserve that has not been given a value yet
+	reserved bool
+	// version is incremented every time the value of the item changes, starting at 1
+	version uint64
 }
 
 // multiKeyCache is the type of the multi-key cache
@@ -70,14 +321,72 @@ type multiKeyCache[PKT comparable, VT any, SKNT comparable, SKT comparable] stru
 	values            map[PKT]item[PKT, VT, SKNT, SKT]
 	indexes           map[SKNT]map[SKT]PKT
 	secondaryKeyNames []SKNT
+	stats             stats
+
+	// configured by options
+	readTransform   func(PKT, VT) VT
+	onEvict         func(PKT, VT, EvictReason)
+	loader          func(context.Context, PKT) (VT, []SKT, bool, error)
+	equal           func(VT, VT) bool
+	negativeTTL     time.Duration
+	defaultTTL      time.Duration
+	maxEntries      int
+	maxCost         int64
+	costFunc        func(VT) int64
+	eventBuffer     int
+	noOverwrite     bool
+	initialCapacity int
+	cloneFunc       func(VT) VT
+	clock           Clock
+	partialKeys     bool
+	observer        Observer
+	conflictPolicy  ConflictPolicy
+	rejectZeroKeys  bool
+	maxNames        int
+	normalizers     map[SKNT]func(SKT) SKT
+
+	// lru orders the primary keys from least to most recently used.
+	// Readers holding the read lock must also hold lruMu to touch it
+	lru            *list.List
+	lruMu          sync.Mutex
+	totalCost      int64
+	evictionPaused bool
+
+	// negatives holds the expiration of recent loader misses when negative caching is enabled.
+	// Once it reaches negativeSweep entries the expired ones are dropped, which keeps it
+	// within twice the number of misses remembered at a time
+	negatives     map[PKT]time.Time
+	negativeSweep int
+
+	// flights holds the loads in progress by GetOrLoadBySecondaryKey, guarded by flightMu
+	flights  map[flightKey[SKNT, SKT]]*flight[VT]
+	flightMu sync.Mutex
+
+	// evicted holds the items removed by the cache itself while holding the write lock,
+	// waiting for the OnEvict hook to be called by unlock
+	evicted []eviction[PKT, VT, SKNT, SKT]
+
+	// observedSets and observedEvictions hold the changes made while holding the write lock,
+	// waiting to be reported to the Observer by unlock
+	observedSets      int
+	observedEvictions []EvictReason
+
+	// events holds the events of the changes made while holding the write lock,
+	// waiting to be delivered to the subscribers by unlock
+	events []Event[PKT, VT]
+
+	// subscribers holds the channels returned by Subscribe, guarded by subMu.
+	// subscribed counts them so writers can skip queueing events without a subscriber
+	subscribers    map[int]chan Event[PKT, VT]
+	nextSubscriber int
+	subMu          sync.Mutex
+	subscribed     atomic.Int32
 }
 
-// NewMultiKeyCache creates a new multi-key cache
+// NewMultiKeyCache creates a new multi-key cache configured with the given options
 // and returns an error if the secondary key names are not unique
-func NewMultiKeyCache[PKT comparable, VT any, SKNT comparable, SKT comparable](secondaryKeyNames []SKNT) (*multiKeyCache[PKT, VT, SKNT, SKT], error) {
+func NewMultiKeyCache[PKT comparable, VT any, SKNT comparable, SKT comparable](secondaryKeyNames []SKNT, opts ...Option[PKT, VT, SKNT, SKT]) (*multiKeyCache[PKT, VT, SKNT, SKT], error) {
 	c := &multiKeyCache[PKT, VT, SKNT, SKT]{
-		values:            make(map[PKT]item[PKT, VT, SKNT, SKT]),
-		indexes:           make(map[SKNT]map[SKT]PKT),
 		secondaryKeyNames: make([]SKNT, len(secondaryKeyNames)),
 	}
 
@@ -90,9 +399,47 @@ func NewMultiKeyCache[PKT comparable, VT any, SKNT comparable, SKT comparable](s
 		seen[name] = true
 	}
 
-	for i, name := range secondaryKeyNames {
-		c.secondaryKeyNames[i] = name
-		c.indexes[name] = make(map[SKT]PKT)
+	copy(c.secondaryKeyNames, secondaryKeyNames)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// check if the number of secondary key names is within the limit
+	if err := c.checkNameCount(len(c.secondaryKeyNames)); err != nil {
+		return nil, err
+	}
+
+	// create the maps once the options are known, so they can be presized
+	c.reset()
+
+	if c.equal == nil {
+		c.equal = defaultEqual[VT]
+	}
+
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+
+	return c, nil
+}
+
+// FromSlice creates a new multi-key cache configured with the given options and sets an item
+// for every element of the slice, in order, using extract to get its primary key, value and
+// secondary keys, e.g. when loading database rows. It returns the first error encountered
+// by NewMultiKeyCache or Set, in which case no cache is returned
+func FromSlice[T any, PKT comparable, VT any, SKNT comparable, SKT comparable](items []T, secondaryKeyNames []SKNT, extract func(T) (pk PKT, v VT, sKeys []SKT), opts ...Option[PKT, VT, SKNT, SKT]) (*multiKeyCache[PKT, VT, SKNT, SKT], error) {
+	opts = append([]Option[PKT, VT, SKNT, SKT]{WithInitialCapacity[PKT, VT, SKNT, SKT](len(items))}, opts...)
+	c, err := NewMultiKeyCache(secondaryKeyNames, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		pk, v, sKeys := extract(item)
+		if err := c.Set(pk, v, sKeys...); err != nil {
+			return nil, err
+		}
 	}
 
 	return c, nil
@@ -103,141 +450,617 @@ func NewMultiKeyCache[PKT comparable, VT any, SKNT comparable, SKT comparable](s
 // and returns an error if the secondary keys do not match the secondary key names
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Set(pk PKT, v VT, sKeys ...SKT) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+
+	_, _, _, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+	return err
+}
+
+// SetIfAbsent works like Set, but only stores the item if the primary key is not in the cache yet.
+// It returns true if the item was stored and false if it already existed
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetIfAbsent(pk PKT, v VT, sKeys ...SKT) (bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if item, ok := c.values[pk]; ok && item.live(c.now()) {
+		return false, nil
+	}
+
+	if _, _, _, err := c.set(pk, v, c.defaultExpiry(), sKeys); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// LoadOrStore returns the value of the item with the given primary key if it is in the cache.
+// Otherwise it stores the given value with the secondary keys like Set and returns it.
+// The loaded result is true if the value was loaded and false if it was stored, mirroring
+// sync.Map. It returns an error if the item cannot be stored
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) LoadOrStore(pk PKT, v VT, sKeys ...SKT) (actual VT, loaded bool, err error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if item, ok := c.values[pk]; ok && item.live(c.now()) {
+		c.touch(item)
+		return c.read(item), true, nil
+	}
+
+	_, it, _, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+	if err != nil {
+		var zero VT
+		return zero, false, err
+	}
+
+	return c.read(it), false, nil
+}
+
+// Reserve claims the secondary keys for a pk before its value is known, e.g. while it is being
+// computed. The reserved item holds the zero value and is treated as missing by Get and the other
+// lookups, but its secondary keys are taken: setting them for a different pk returns
+// ErrWrongSecondaryKey. A later Set for the pk fills in the value. The reservation itself is not
+// counted as a set or reported to the subscribers. Reserve returns ErrPrimaryKeyExists if the pk
+// already holds a value
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Reserve(pk PKT, sKeys ...SKT) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if item, ok := c.values[pk]; ok && item.live(c.now()) {
+		return ErrPrimaryKeyExists[PKT]{PK: pk}
+	}
+
+	var zero VT
+	_, _, _, err := c.setItem(pk, zero, time.Time{}, sKeys, true)
+	return err
+}
+
+// SetWithNamedKeys works like Set, but takes the secondary keys as a map from secondary key name
+// to secondary key. It returns an error if the map lacks one of the secondary key names
+// or contains a name that does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetWithNamedKeys(pk PKT, v VT, keys map[SKNT]SKT) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// check if every name in the map exists
+	for skn := range keys {
+		if !c.secondaryKeyNameExists(skn) {
+			return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+	}
+
+	// put the secondary keys in the order of the names
+	sKeys := make([]SKT, len(c.secondaryKeyNames))
+	for i, skn := range c.secondaryKeyNames {
+		sk, ok := keys[skn]
+		if !ok {
+			return ErrMissingSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+		sKeys[i] = sk
+	}
+
+	_, _, _, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+	return err
+}
+
+// SetReturning works like Set, but also returns the secondary keys of the item before
+// and after the change and a boolean indicating if the item existed before
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetReturning(pk PKT, v VT, sKeys ...SKT) (oldKeys, newKeys map[SKNT]SKT, existed bool, err error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	old, item, existed, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if existed {
+		oldKeys = copySecondaryKeys(old.secondaryKeys)
+	}
 
-	// check if the number of secondary keys matches the number of secondary key names
-	if len(sKeys) != len(c.secondaryKeyNames) {
-		return ErrSecondaryKeyNumberMismatch{Expected: len(c.secondaryKeyNames), Actual: len(sKeys)}
+	return oldKeys, copySecondaryKeys(item.secondaryKeys), existed, nil
+}
+
+// set stores the item and replaces the index entries of any previous item with the same pk,
+// then evicts items as needed to stay within the limits of the cache.
+// Secondary keys held by expired items of a different pk are taken over, and so are those held
+// by live items under the OverwriteExisting conflict policy, removing the items holding them.
+// With WithNoOverwrite, a live item with the same pk is an error.
+// It returns the previous item, the stored item and a boolean indicating if the item existed.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) set(pk PKT, v VT, expiresAt time.Time, sKeys []SKT) (old, it item[PKT, VT, SKNT, SKT], existed bool, err error) {
+	return c.setItem(pk, v, expiresAt, sKeys, false)
+}
+
+// setItem works like set, but stores the item as reserved if asked to. A reservation is neither
+// counted as a set nor reported to the subscribers, since it holds no value yet.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) setItem(pk PKT, v VT, expiresAt time.Time, sKeys []SKT, reserved bool) (old, it item[PKT, VT, SKNT, SKT], existed bool, err error) {
+	// check if the secondary keys may be set
+	sKeys = c.normalizeKeys(sKeys)
+	if err := c.checkKeys(sKeys); err != nil {
+		return old, it, false, err
+	}
+
+	// check if the item may be overwritten
+	now := c.now()
+	if existing, ok := c.values[pk]; ok && c.noOverwrite && existing.live(now) {
+		return old, it, false, ErrPrimaryKeyExists[PKT]{PK: pk}
 	}
 
 	// check if the secondary keys already exist for a different pk
-	for i, k := range c.secondaryKeyNames {
+	var expired, replaced []item[PKT, VT, SKNT, SKT]
+	for i, k := range c.secondaryKeyNames[:len(sKeys)] {
 		if spk, ok := c.indexes[k][sKeys[i]]; ok {
 			if spk != pk {
-				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: k, ExistingPK: spk, NewPK: pk}
+				owner := c.values[spk]
+				if owner.expired(now) {
+					expired = append(expired, owner)
+					continue
+				}
+				if c.conflictPolicy == OverwriteExisting {
+					replaced = append(replaced, owner)
+					continue
+				}
+				return old, it, false, ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: k, ExistingPK: spk, NewPK: pk}
 			}
 		}
 	}
 
-	// create the item
-	item := item[PKT, VT, SKNT, SKT]{
-		pk:            pk,
-		value:         v,
-		secondaryKeys: make(map[SKNT]SKT),
+	// drop the expired and replaced items holding any of the secondary keys
+	for _, owner := range expired {
+		if _, ok := c.values[owner.pk]; ok {
+			c.evict(owner, EvictReasonExpired)
+		}
 	}
+	for _, owner := range replaced {
+		if _, ok := c.values[owner.pk]; ok {
+			c.evict(owner, EvictReasonReplaced)
+		}
+	}
+
+	// create the item
+	it.pk = pk
+	it.value = c.cloneValue(v)
+	it.expiresAt = expiresAt
+	it.reserved = reserved
+	it.secondaryKeys = make(map[SKNT]SKT)
 
 	// set the secondary keys
 	for i, sKey := range sKeys {
-		item.secondaryKeys[c.secondaryKeyNames[i]] = sKey
+		it.secondaryKeys[c.secondaryKeyNames[i]] = sKey
 	}
 
-	// set the item in the cache
-	c.values[pk] = item
+	// set the item in the cache, replacing any item being overwritten, and take the stored
+	// item with its version, as it may be evicted below
+	old, existed = c.store(it)
+	it = c.values[pk]
+
+	if !reserved {
+		c.countSets(1)
+	}
+
+	c.evictOverflow()
+
+	return old, it, existed, nil
+}
+
+// normalize returns the secondary key in the form it is indexed under the given name,
+// as produced by the normalizer registered with WithKeyNormalizer, if any
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) normalize(skn SKNT, sk SKT) SKT {
+	if norm, ok := c.normalizers[skn]; ok {
+		return norm(sk)
+	}
+
+	return sk
+}
+
+// normalizeKeys returns the secondary keys, given in the order of the names, in normalized form.
+// The keys are copied only when there is a normalizer
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) normalizeKeys(sKeys []SKT) []SKT {
+	if len(c.normalizers) == 0 {
+		return sKeys
+	}
+
+	normalized := make([]SKT, len(sKeys))
+	for i, sk := range sKeys {
+		if i < len(c.secondaryKeyNames) {
+			sk = c.normalize(c.secondaryKeyNames[i], sk)
+		}
+		normalized[i] = sk
+	}
+
+	return normalized
+}
+
+// checkNameCount returns an error if the cache may not have n secondary key names
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) checkNameCount(n int) error {
+	if c.maxNames > 0 && n > c.maxNames {
+		return ErrTooManySecondaryKeyNames{Max: c.maxNames, Actual: n}
+	}
+
+	return nil
+}
+
+// checkKeys returns an error if the secondary keys cannot be set for an item, because
+// their number is wrong or one of them is the zero value with WithRejectZeroSecondaryKeys
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) checkKeys(sKeys []SKT) error {
+	if err := c.checkKeyCount(len(sKeys)); err != nil {
+		return err
+	}
+
+	if c.rejectZeroKeys {
+		var zero SKT
+		for i, sk := range sKeys {
+			if sk == zero {
+				return ErrZeroSecondaryKey[SKNT]{SecondaryKeyName: c.secondaryKeyNames[i]}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkKeyCount returns an error if n secondary keys cannot be set for an item,
+// which must be one per secondary key name, or at most that many with WithPartialSecondaryKeys
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) checkKeyCount(n int) error {
+	if n == len(c.secondaryKeyNames) || (c.partialKeys && n < len(c.secondaryKeyNames)) {
+		return nil
+	}
+
+	return ErrSecondaryKeyNumberMismatch{Expected: len(c.secondaryKeyNames), Actual: n}
+}
 
-	// set the secondary keys in the indexes
-	for _, k := range c.secondaryKeyNames {
-		c.indexes[k][item.secondaryKeys[k]] = pk
+// Rekey moves the item with the primary key oldPK to newPK, keeping its value and secondary keys,
+// which then resolve to newPK. It returns ErrPrimaryKeyNotFound if there is no item with oldPK,
+// and ErrPrimaryKeyExists if newPK is already used by another item
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Rekey(oldPK, newPK PKT) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.now()
+	it, ok := c.values[oldPK]
+	if !ok || !it.live(now) {
+		return ErrPrimaryKeyNotFound[PKT]{PK: oldPK}
+	}
+
+	if oldPK == newPK {
+		return nil
+	}
+
+	// an expired item gives up its pk like it gives up its secondary keys
+	if existing, ok := c.values[newPK]; ok {
+		if !existing.expired(now) {
+			return ErrPrimaryKeyExists[PKT]{PK: newPK}
+		}
+		c.evict(existing, EvictReasonExpired)
 	}
 
+	// move the item and its index entries
+	c.deleteItem(it)
+	it.pk = newPK
+	c.store(it)
+
 	return nil
 }
 
 // Get returns the value of the item with the given primary key
 // and a boolean indicating if the item was found
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Get(pk PKT) (VT, bool) {
+	var l lookups
+	defer c.observeLookups(&l)
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// get the item by primary key
 	item, ok := c.values[pk]
-	if !ok {
+	if !ok || !item.live(c.now()) {
+		l.misses++
 		var v VT
 		return v, false
 	}
 
-	return item.value, true
+	l.hits++
+	c.touch(item)
+	return c.read(item), true
 }
 
 // GetBySecondaryKey returns the value of the item with the given secondary key
 // and a boolean indicating if the item was found
 // and an error if the secondary key name does not exist
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetBySecondaryKey(skn SKNT, sk SKT) (VT, bool, error) {
+	_, v, found, err := c.GetEntryBySecondaryKey(skn, sk)
+	return v, found, err
+}
+
+// GetEntryBySecondaryKey works like GetBySecondaryKey, but also returns the primary key of the item
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetEntryBySecondaryKey(skn SKNT, sk SKT) (pk PKT, v VT, found bool, err error) {
+	var l lookups
+	defer c.observeLookups(&l)
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	var zero VT
-
 	// check if the secondary key name exists
 	if !c.secondaryKeyNameExists(skn) {
-		return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		return pk, v, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
 	}
 
 	// check if the secondary key exists
-	pk, ok := c.indexes[skn][sk]
+	spk, ok := c.indexes[skn][c.normalize(skn, sk)]
 	if !ok {
-		return zero, false, nil
+		l.misses++
+		return pk, v, false, nil
 	}
 
 	// get the item by primary key
-	value, ok := c.Get(pk)
+	item, ok := c.values[spk]
+	if !ok || !item.live(c.now()) {
+		l.misses++
+		return pk, v, false, nil
+	}
 
-	return value, ok, nil
+	l.hits++
+	c.touch(item)
+	return spk, c.read(item), true, nil
 }
 
-// Delete deletes the item with the given primary key
-func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Delete(pk PKT) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// GetBySecondaryKeyOrDefault works like GetBySecondaryKey, but returns def
+// instead of a boolean when the item is not found
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetBySecondaryKeyOrDefault(skn SKNT, sk SKT, def VT) (VT, error) {
+	v, ok, err := c.GetBySecondaryKey(skn, sk)
+	if err != nil {
+		return v, err
+	}
 
-	// find the item
-	item, ok := c.values[pk]
 	if !ok {
-		return
+		return def, nil
 	}
 
-	// delete the item
-	delete(c.values, pk)
-
-	// delete the secondary keys from the indexes
-	for _, skn := range c.secondaryKeyNames {
-		delete(c.indexes[skn], item.secondaryKeys[skn])
-	}
+	return v, nil
 }
 
-// DeleteBySecondaryKey deletes the item with the given secondary key
-// and returns an error if the secondary key name does not exist
-func (c *multiKeyCache[PKT, VT, SKNT, SKT]) DeleteBySecondaryKey(skn SKNT, sk SKT) error {
+// GetManyBySecondaryKey returns the values of the items with the given secondary keys
+// under a single lock, keyed by secondary key, and returns an error if the secondary key name
+// does not exist. Secondary keys that are not found are absent from the result
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetManyBySecondaryKey(skn SKNT, sks []SKT) (map[SKT]VT, error) {
+	var l lookups
+	defer c.observeLookups(&l)
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// check if the secondary key name exists
 	if !c.secondaryKeyNameExists(skn) {
-		return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
-	}
-
-	// find the item
-	pk, ok := c.indexes[skn][sk]
-	if !ok {
-		return nil
+		return nil, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
 	}
 
-	// find the item
-	item, ok := c.values[pk]
-	if !ok {
-		return nil
-	}
+	now := c.now()
+	values := make(map[SKT]VT, len(sks))
+	for _, sk := range sks {
+		pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+		if !ok {
+			l.misses++
+			continue
+		}
 
-	// delete the item by primary key
-	delete(c.values, pk)
+		item, ok := c.values[pk]
+		if !ok || !item.live(now) {
+			l.misses++
+			continue
+		}
 
-	// delete the secondary keys from the indexes
-	for _, skn := range c.secondaryKeyNames {
-		delete(c.indexes[skn], item.secondaryKeys[skn])
+		l.hits++
+		c.touch(item)
+		values[sk] = c.read(item)
 	}
 
+	return values, nil
+}
+
+// ContainsAllSecondaryKeys returns true if every one of the given secondary keys identifies
+// an item under the given secondary key name, and returns an error if the name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ContainsAllSecondaryKeys(skn SKNT, sks []SKT) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	now := c.now()
+	for _, sk := range sks {
+		if !c.containsSecondaryKey(skn, sk, now) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// HasAnySecondaryKey returns true if at least one of the given secondary keys identifies
+// an item under the given secondary key name, and returns an error if the name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) HasAnySecondaryKey(skn SKNT, sks []SKT) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	now := c.now()
+	for _, sk := range sks {
+		if c.containsSecondaryKey(skn, sk, now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// containsSecondaryKey returns true if the secondary key identifies a live item.
+// The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) containsSecondaryKey(skn SKNT, sk SKT, now time.Time) bool {
+	pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+	if !ok {
+		return false
+	}
+
+	item, ok := c.values[pk]
+	return ok && item.live(now)
+}
+
+// Peek works like Get, but does not count as a use of the item,
+// so it neither changes the eviction order nor the hit/miss statistics
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Peek(pk PKT) (VT, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		var v VT
+		return v, false
+	}
+
+	return c.read(item), true
+}
+
+// PeekBySecondaryKey works like GetBySecondaryKey, but does not count as a use of the item,
+// so it neither changes the eviction order nor the hit/miss statistics
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) PeekBySecondaryKey(skn SKNT, sk SKT) (VT, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var zero VT
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+	if !ok {
+		return zero, false, nil
+	}
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		return zero, false, nil
+	}
+
+	return c.read(item), true, nil
+}
+
+// Delete deletes the item with the given primary key
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Delete(pk PKT) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// find the item
+	item, ok := c.values[pk]
+	if !ok {
+		return
+	}
+
+	// delete the item and its secondary keys
+	c.deleteItem(item)
+}
+
+// DeleteBySecondaryKey deletes the item with the given secondary key
+// and returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) DeleteBySecondaryKey(skn SKNT, sk SKT) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	// find the item
+	pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+	if !ok {
+		return nil
+	}
+
+	// find the item
+	item, ok := c.values[pk]
+	if !ok {
+		return nil
+	}
+
+	// delete the item and its secondary keys
+	c.deleteItem(item)
+
 	return nil
 }
 
+// GetAndDelete returns the value of the item with the given primary key and deletes it
+// under a single lock, and returns a boolean indicating if the item was found
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetAndDelete(pk PKT) (VT, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	return c.getAndDelete(pk)
+}
+
+// GetAndDeleteBySecondaryKey works like GetAndDelete for the item with the given secondary key
+// and returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetAndDeleteBySecondaryKey(skn SKNT, sk SKT) (VT, bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	var zero VT
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+	if !ok {
+		return zero, false, nil
+	}
+
+	v, ok := c.getAndDelete(pk)
+	return v, ok, nil
+}
+
+// getAndDelete deletes a live item and returns its value.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) getAndDelete(pk PKT) (VT, bool) {
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		var v VT
+		return v, false
+	}
+
+	c.deleteItem(item)
+
+	return c.read(item), true
+}
+
+// read returns the value of the item as seen by the read methods
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) read(item item[PKT, VT, SKNT, SKT]) VT {
+	v := c.cloneValue(item.value)
+	if c.readTransform != nil {
+		return c.readTransform(item.pk, v)
+	}
+
+	return v
+}
+
+// cloneValue returns a copy of the value made by the value cloner, if one is configured
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) cloneValue(v VT) VT {
+	if c.cloneFunc != nil {
+		return c.cloneFunc(v)
+	}
+
+	return v
+}
+
 // secondaryKeyNameExists returns true if the secondary key name exists
 // and false otherwise
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) secondaryKeyNameExists(skn SKNT) bool {
@@ -251,34 +1074,132 @@ func (c *multiKeyCache[PKT, VT, SKNT, SKT]) secondaryKeyNameExists(skn SKNT) boo
 }
 
 // Clear clears the entire cache. All of it. Gone.
+// If an OnEvict hook is configured, it is called for every removed item
+// with EvictReasonCleared once the lock has been released
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Clear() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// queue the items for the hook and the subscribers before dropping them
+	for _, item := range c.values {
+		c.queueEviction(item, EvictReasonCleared)
+	}
+
+	c.reset()
+}
+
+// Drain removes all the items from the cache and returns the values of the live ones
+// under a single lock, so no item set in between is lost as with GetAll followed by Clear.
+// The OnEvict hook is called for every removed item with EvictReasonCleared
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Drain() map[PKT]VT {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.now()
+	values := make(map[PKT]VT, len(c.values))
+	for pk, item := range c.values {
+		if item.live(now) {
+			values[pk] = c.read(item)
+		}
+		c.queueEviction(item, EvictReasonCleared)
+	}
+
+	c.reset()
+
+	return values
+}
+
+// reset replaces the values and indexes with empty maps, presized to the initial capacity.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) reset() {
+	c.values = make(map[PKT]item[PKT, VT, SKNT, SKT], c.initialCapacity)
+	c.lru = list.New()
+	c.totalCost = 0
+	c.negatives = nil
+	c.indexes = make(map[SKNT]map[SKT]PKT, len(c.secondaryKeyNames))
+	for _, skn := range c.secondaryKeyNames {
+		c.indexes[skn] = make(map[SKT]PKT, c.initialCapacity)
+	}
+}
+
+// Trim rebuilds the values and the secondary indexes into maps sized for their current contents,
+// releasing the memory Go maps keep after many items have been deleted, e.g. after a bulk load
+// followed by a mass deletion. It copies every item and index entry under the write lock,
+// so it is an O(n) operation that blocks the cache while it runs
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Trim() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.values = make(map[PKT]item[PKT, VT, SKNT, SKT])
-	c.indexes = make(map[SKNT]map[SKT]PKT)
+	values := make(map[PKT]item[PKT, VT, SKNT, SKT], len(c.values))
+	for pk, item := range c.values {
+		values[pk] = item
+	}
+	c.values = values
+	c.indexes = c.copyIndexes()
+
+	if c.negatives != nil {
+		negatives := make(map[PKT]time.Time, len(c.negatives))
+		for pk, expiresAt := range c.negatives {
+			negatives[pk] = expiresAt
+		}
+		c.negatives = negatives
+	}
 }
 
-// Len returns the number of items in the cache
+// Len returns the number of live items in the cache. Expired items that have not been
+// removed yet and reserved items are not counted
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Len() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return len(c.values)
+	now := c.now()
+	n := 0
+	for _, item := range c.values {
+		if item.live(now) {
+			n++
+		}
+	}
+	return n
 }
 
-// Keys returns a slice of all the primary keys in the cache
+// Keys returns a slice of the primary keys of all the live items in the cache
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Keys() []PKT {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	now := c.now()
 	keys := make([]PKT, 0, len(c.values))
-	for pk := range c.values {
-		keys = append(keys, pk)
+	for pk, item := range c.values {
+		if item.live(now) {
+			keys = append(keys, pk)
+		}
 	}
 	return keys
 }
 
+// SortedKeys returns a slice of all the primary keys in the cache, sorted in ascending order.
+// It is a function rather than a method since it requires an ordered primary key type
+func SortedKeys[PKT cmp.Ordered, VT any, SKNT comparable, SKT comparable](c *multiKeyCache[PKT, VT, SKNT, SKT]) []PKT {
+	keys := c.Keys()
+	slices.Sort(keys)
+	return keys
+}
+
+// Values returns a slice of the values of all the live items in the cache, in no particular order
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Values() []VT {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	values := make([]VT, 0, len(c.values))
+	for _, item := range c.values {
+		if item.live(now) {
+			values = append(values, c.read(item))
+		}
+	}
+	return values
+}
+
 // SecondaryKeyNames returns a slice of all the secondary key names in the cache
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeyNames() []SKNT {
 	c.mu.RLock()
@@ -287,15 +1208,22 @@ func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeyNames() []SKNT {
 	return c.secondaryKeyNames
 }
 
-// SecondaryKeys returns a slice of all the secondary keys in the cache
-// for the given secondary key name
+// SecondaryKeys returns a slice of the secondary keys of all the live items in the cache
+// for the given secondary key name, in no particular order. Use SortedSecondaryKeys
+// for a deterministic order
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeys(skn SKNT) []SKT {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	keys := make([]SKT, 0, len(c.indexes[skn]))
-	for sk := range c.indexes[skn] {
-		keys = append(keys, sk)
+	index := c.indexes[skn]
+	keys := make([]SKT, 0, len(index))
+
+	now := c.now()
+	for sk, pk := range index {
+		item := c.values[pk]
+		if isk, ok := item.secondaryKeys[skn]; ok && isk == sk && item.live(now) {
+			keys = append(keys, sk)
+		}
 	}
 	return keys
 }
@@ -309,14 +1237,1219 @@ func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeyNameToKeys(skn SKNT) map
 	return c.indexes[skn]
 }
 
-// GetAll returns a map of all the items in the cache
+// SortedSecondaryKeys works like SecondaryKeys, but returns the keys sorted in ascending order
+// and an error if the secondary key name does not exist.
+// It is a function rather than a method since it requires an ordered secondary key type
+func SortedSecondaryKeys[PKT comparable, VT any, SKNT comparable, SKT cmp.Ordered](c *multiKeyCache[PKT, VT, SKNT, SKT], skn SKNT) ([]SKT, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return nil, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	now := c.now()
+	keys := make([]SKT, 0, len(c.indexes[skn]))
+	for sk, pk := range c.indexes[skn] {
+		item := c.values[pk]
+		if isk, ok := item.secondaryKeys[skn]; ok && isk == sk && item.live(now) {
+			keys = append(keys, sk)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// GroupBySecondaryKeyName returns the values of the live items keyed by their secondary key
+// under the given name, and an error if the secondary key name does not exist. Since secondary
+// keys are unique, every slice holds a single value. Items without a secondary key under the name,
+// e.g. after ClearIndex, are left out
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GroupBySecondaryKeyName(skn SKNT) (map[SKT][]VT, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return nil, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	now := c.now()
+	groups := make(map[SKT][]VT, len(c.indexes[skn]))
+	for sk, pk := range c.indexes[skn] {
+		item, ok := c.values[pk]
+		if !ok || !item.live(now) {
+			continue
+		}
+		groups[sk] = append(groups[sk], c.read(item))
+	}
+	return groups, nil
+}
+
+// GetAll returns a map of all the live items in the cache
 func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetAll() map[PKT]VT {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	now := c.now()
 	values := make(map[PKT]VT)
 	for pk, item := range c.values {
-		values[pk] = item.value
+		if item.live(now) {
+			values[pk] = c.read(item)
+		}
 	}
 	return values
 }
+
+// IndexDifference describes how a single secondary index differs between two caches
+type IndexDifference[SKT comparable] struct {
+	OnlyHere  []SKT
+	OnlyThere []SKT
+}
+
+// IndexDiff returns the per secondary key name differences between the indexes of this cache
+// and the other cache. OnlyHere holds the secondary keys indexed only in this cache, OnlyThere
+// the ones indexed only in the other. A secondary key pointing to different primary keys
+// in the two caches is reported on both sides. Names without any differences are omitted
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) IndexDiff(other *multiKeyCache[PKT, VT, SKNT, SKT]) map[SKNT]IndexDifference[SKT] {
+	// copy the other indexes first, so we never hold both locks at the same time
+	other.mu.RLock()
+	there := other.copyIndexes()
+	otherNames := append([]SKNT(nil), other.secondaryKeyNames...)
+	other.mu.RUnlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// compare every name known to either cache
+	names := append([]SKNT(nil), c.secondaryKeyNames...)
+	for _, skn := range otherNames {
+		if !c.secondaryKeyNameExists(skn) {
+			names = append(names, skn)
+		}
+	}
+
+	diff := make(map[SKNT]IndexDifference[SKT])
+	for _, skn := range names {
+		var d IndexDifference[SKT]
+
+		for sk, pk := range c.indexes[skn] {
+			if opk, ok := there[skn][sk]; !ok || opk != pk {
+				d.OnlyHere = append(d.OnlyHere, sk)
+			}
+		}
+
+		for sk, opk := range there[skn] {
+			if pk, ok := c.indexes[skn][sk]; !ok || pk != opk {
+				d.OnlyThere = append(d.OnlyThere, sk)
+			}
+		}
+
+		if len(d.OnlyHere) > 0 || len(d.OnlyThere) > 0 {
+			diff[skn] = d
+		}
+	}
+
+	return diff
+}
+
+// Diff compares the live items of the cache with those of another cache with the same secondary
+// key names. It returns the primary keys only in the other cache as added, those only in this cache
+// as removed, and those in both with values that differ according to the equality function of this
+// cache as changed, each in no particular order. It returns an error if the secondary key names differ
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Diff(other *multiKeyCache[PKT, VT, SKNT, SKT]) (added, removed, changed []PKT, err error) {
+	// copy the other values first, so we never hold both locks at the same time
+	other.mu.RLock()
+	otherNames := append([]SKNT(nil), other.secondaryKeyNames...)
+	now := other.now()
+	there := make(map[PKT]VT, len(other.values))
+	for pk, it := range other.values {
+		if it.live(now) {
+			there[pk] = it.value
+		}
+	}
+	other.mu.RUnlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key names match
+	if !slices.Equal(c.secondaryKeyNames, otherNames) {
+		return nil, nil, nil, ErrSecondaryKeyNamesMismatch[SKNT]{Expected: c.secondaryKeyNames, Actual: otherNames}
+	}
+
+	now = c.now()
+	for pk, it := range c.values {
+		if !it.live(now) {
+			continue
+		}
+
+		v, ok := there[pk]
+		if !ok {
+			removed = append(removed, pk)
+		} else if !c.equal(it.value, v) {
+			changed = append(changed, pk)
+		}
+		delete(there, pk)
+	}
+
+	for pk := range there {
+		added = append(added, pk)
+	}
+
+	return added, removed, changed, nil
+}
+
+// copyIndexes returns a deep copy of the secondary indexes.
+// The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) copyIndexes() map[SKNT]map[SKT]PKT {
+	indexes := make(map[SKNT]map[SKT]PKT, len(c.indexes))
+	for skn, index := range c.indexes {
+		indexes[skn] = make(map[SKT]PKT, len(index))
+		for sk, pk := range index {
+			indexes[skn][sk] = pk
+		}
+	}
+	return indexes
+}
+
+// Clone returns an independent copy of the cache, including all the secondary indexes.
+// The values themselves are copied shallowly, so values containing pointers, slices
+// or maps will share the underlying data with the original
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Clone() *multiKeyCache[PKT, VT, SKNT, SKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := &multiKeyCache[PKT, VT, SKNT, SKT]{
+		values:            make(map[PKT]item[PKT, VT, SKNT, SKT], len(c.values)),
+		indexes:           c.copyIndexes(),
+		secondaryKeyNames: append([]SKNT(nil), c.secondaryKeyNames...),
+		readTransform:     c.readTransform,
+		onEvict:           c.onEvict,
+		lru:               list.New(),
+		maxEntries:        c.maxEntries,
+		maxCost:           c.maxCost,
+		costFunc:          c.costFunc,
+		loader:            c.loader,
+		equal:             c.equal,
+		negativeTTL:       c.negativeTTL,
+		defaultTTL:        c.defaultTTL,
+		eventBuffer:       c.eventBuffer,
+		noOverwrite:       c.noOverwrite,
+		initialCapacity:   c.initialCapacity,
+		cloneFunc:         c.cloneFunc,
+		clock:             c.clock,
+		partialKeys:       c.partialKeys,
+		observer:          c.observer,
+		conflictPolicy:    c.conflictPolicy,
+		rejectZeroKeys:    c.rejectZeroKeys,
+		maxNames:          c.maxNames,
+		normalizers:       c.normalizers,
+		totalCost:         c.totalCost,
+	}
+
+	// copy the items in recency order
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		it := c.values[e.Value.(PKT)]
+		it.value = c.cloneValue(it.value)
+		it.secondaryKeys = copySecondaryKeys(it.secondaryKeys)
+		it.elem = n.lru.PushBack(it.pk)
+		n.values[it.pk] = it
+	}
+
+	return n
+}
+
+// copySecondaryKeys returns a copy of an item's secondary keys
+func copySecondaryKeys[SKNT comparable, SKT comparable](secondaryKeys map[SKNT]SKT) map[SKNT]SKT {
+	keys := make(map[SKNT]SKT, len(secondaryKeys))
+	for skn, sk := range secondaryKeys {
+		keys[skn] = sk
+	}
+	return keys
+}
+
+// Merge copies all the items from the other cache into this cache, overwriting items
+// with the same primary key. Both caches must have the same secondary key names in the same order.
+// It returns an error if a secondary key from the other cache already exists for a different pk,
+// in which case this cache is left unchanged
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Merge(other *multiKeyCache[PKT, VT, SKNT, SKT]) error {
+	// copy the other items first, so we never hold both locks at the same time
+	other.mu.RLock()
+	otherNames := append([]SKNT(nil), other.secondaryKeyNames...)
+	items := make([]item[PKT, VT, SKNT, SKT], 0, len(other.values))
+	for _, it := range other.values {
+		it.value = c.cloneValue(it.value)
+		it.secondaryKeys = copySecondaryKeys(it.secondaryKeys)
+		items = append(items, it)
+	}
+	other.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	// check if the secondary key names match
+	if !slices.Equal(c.secondaryKeyNames, otherNames) {
+		return ErrSecondaryKeyNamesMismatch[SKNT]{Expected: c.secondaryKeyNames, Actual: otherNames}
+	}
+
+	// check all the items for conflicts before changing anything
+	for _, it := range items {
+		for skn, sk := range it.secondaryKeys {
+			if spk, ok := c.indexes[skn][sk]; ok && spk != it.pk {
+				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: it.pk}
+			}
+		}
+	}
+
+	// copy the items, replacing any item being overwritten
+	for _, it := range items {
+		c.store(it)
+	}
+	c.countSets(len(items))
+
+	c.evictOverflow()
+
+	return nil
+}
+
+// store puts the item in the cache and the indexes, replacing any item with the same pk,
+// and marks it as the most recently used. It returns the replaced item, if any.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) store(it item[PKT, VT, SKNT, SKT]) (old item[PKT, VT, SKNT, SKT], existed bool) {
+	old, existed = c.values[it.pk]
+	if existed {
+		c.unindex(old)
+		c.totalCost -= old.cost
+		it.elem = old.elem
+		it.version = old.version
+		c.lru.MoveToBack(it.elem)
+	} else {
+		it.elem = c.lru.PushBack(it.pk)
+	}
+	it.version++
+
+	it.cost = 0
+	c.recost(&it)
+
+	c.values[it.pk] = it
+	c.index(it)
+	delete(c.negatives, it.pk)
+	if !it.reserved {
+		c.emit(EventSet, it, 0)
+	}
+
+	return old, existed
+}
+
+// remove deletes the item from the cache and the indexes.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) remove(it item[PKT, VT, SKNT, SKT]) {
+	c.unindex(it)
+	c.lru.Remove(it.elem)
+	c.totalCost -= it.cost
+	delete(c.values, it.pk)
+}
+
+// deleteItem removes the item on behalf of the caller and queues an EventDelete for it.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) deleteItem(it item[PKT, VT, SKNT, SKT]) {
+	c.remove(it)
+	c.emit(EventDelete, it, 0)
+}
+
+// index adds the secondary keys of the item to the indexes.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) index(it item[PKT, VT, SKNT, SKT]) {
+	for skn, sk := range it.secondaryKeys {
+		c.indexes[skn][sk] = it.pk
+	}
+}
+
+// unindex removes the secondary keys of the item from the indexes,
+// leaving entries that point to a different pk alone.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) unindex(it item[PKT, VT, SKNT, SKT]) {
+	for skn, sk := range it.secondaryKeys {
+		if pk, ok := c.indexes[skn][sk]; ok && pk == it.pk {
+			delete(c.indexes[skn], sk)
+		}
+	}
+}
+
+// UnindexedPrimaryKeys returns the primary keys of the items that are not referenced
+// by any secondary index entry, which indicates a corrupted index.
+// Items without any secondary keys have nothing to index and are never returned
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) UnindexedPrimaryKeys() []PKT {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// collect every pk referenced by an index
+	referenced := make(map[PKT]bool, len(c.values))
+	for _, index := range c.indexes {
+		for _, pk := range index {
+			referenced[pk] = true
+		}
+	}
+
+	keys := make([]PKT, 0)
+	for pk, item := range c.values {
+		if len(item.secondaryKeys) > 0 && !referenced[pk] {
+			keys = append(keys, pk)
+		}
+	}
+	return keys
+}
+
+// UnindexedKeys returns the primary keys of the items that have no entry in the index for the
+// given secondary key name, e.g. because they were set with partial secondary keys or the
+// index was cleared. It returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) UnindexedKeys(skn SKNT) ([]PKT, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.secondaryKeyNameExists(skn) {
+		return nil, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	keys := make([]PKT, 0)
+	for pk, item := range c.values {
+		sk, ok := item.secondaryKeys[skn]
+		if !ok {
+			keys = append(keys, pk)
+			continue
+		}
+		if spk, ok := c.indexes[skn][sk]; !ok || spk != pk {
+			keys = append(keys, pk)
+		}
+	}
+	return keys, nil
+}
+
+// Reindex rebuilds all the secondary indexes from the secondary keys stored with each item
+// and returns an error if a secondary key is used by more than one primary key,
+// in which case the existing indexes are left unchanged
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Reindex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	indexes := make(map[SKNT]map[SKT]PKT, len(c.secondaryKeyNames))
+	for _, skn := range c.secondaryKeyNames {
+		indexes[skn] = make(map[SKT]PKT)
+	}
+
+	for pk, item := range c.values {
+		for skn, sk := range item.secondaryKeys {
+			if spk, ok := indexes[skn][sk]; ok && spk != pk {
+				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: pk}
+			}
+			indexes[skn][sk] = pk
+		}
+	}
+
+	c.indexes = indexes
+
+	return nil
+}
+
+// GetByKeyCombination returns the value of the item identified by all the given secondary keys
+// and a boolean indicating if a single item matches all of them.
+// It returns an error if a secondary key name does not exist
+// or if the secondary keys resolve to different items
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetByKeyCombination(keys map[SKNT]SKT) (VT, bool, error) {
+	var l lookups
+	defer c.observeLookups(&l)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var zero VT
+
+	// check if the secondary key names exist
+	for skn := range keys {
+		if !c.secondaryKeyNameExists(skn) {
+			return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+	}
+
+	// resolve every secondary key
+	pks := make(map[SKNT]PKT, len(keys))
+	for skn, sk := range keys {
+		if pk, ok := c.indexes[skn][c.normalize(skn, sk)]; ok {
+			pks[skn] = pk
+		}
+	}
+
+	// check that all the resolved keys agree
+	var pk PKT
+	first := true
+	for _, spk := range pks {
+		if first {
+			pk, first = spk, false
+		} else if spk != pk {
+			return zero, false, ErrInconsistentSecondaryKeys[PKT, SKNT]{PrimaryKeys: pks}
+		}
+	}
+
+	// every secondary key must match
+	if len(keys) == 0 || len(pks) != len(keys) {
+		l.misses++
+		return zero, false, nil
+	}
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		l.misses++
+		return zero, false, nil
+	}
+
+	l.hits++
+	c.touch(item)
+	return c.read(item), true, nil
+}
+
+// CountBySecondaryKeyName returns the number of secondary keys indexed under the given name
+// and an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) CountBySecondaryKeyName(skn SKNT) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return 0, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	return len(c.indexes[skn]), nil
+}
+
+// Count works like CountBySecondaryKeyName
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Count(skn SKNT) (int, error) {
+	return c.CountBySecondaryKeyName(skn)
+}
+
+// CountConsistent returns true if every secondary index has exactly one entry per item,
+// counting expired items that have not been removed yet. It only compares the sizes, so it is
+// cheap enough to call at runtime, but misses entries pointing to the wrong item; use Verify
+// for a full check. Items without a secondary key, e.g. after ClearIndex or when set with
+// partial secondary keys, also make it return false
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) CountConsistent() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, skn := range c.secondaryKeyNames {
+		if len(c.indexes[skn]) != len(c.values) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexSnapshotEntry is a single secondary index entry as returned by IndexSnapshot
+type IndexSnapshotEntry[SKT comparable, PKT comparable] struct {
+	SK SKT
+	PK PKT
+}
+
+// IndexSnapshot returns the entries of every secondary index, sorted by secondary key using the
+// comparator given for the secondary key name. The entries of names without a comparator
+// are returned in no particular order, which may differ between calls
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) IndexSnapshot(less map[SKNT]func(a, b SKT) bool) map[SKNT][]IndexSnapshotEntry[SKT, PKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := make(map[SKNT][]IndexSnapshotEntry[SKT, PKT], len(c.secondaryKeyNames))
+	for _, skn := range c.secondaryKeyNames {
+		entries := make([]IndexSnapshotEntry[SKT, PKT], 0, len(c.indexes[skn]))
+		for sk, pk := range c.indexes[skn] {
+			entries = append(entries, IndexSnapshotEntry[SKT, PKT]{SK: sk, PK: pk})
+		}
+
+		if lessFn, ok := less[skn]; ok {
+			slices.SortFunc(entries, func(a, b IndexSnapshotEntry[SKT, PKT]) int {
+				switch {
+				case lessFn(a.SK, b.SK):
+					return -1
+				case lessFn(b.SK, a.SK):
+					return 1
+				default:
+					return 0
+				}
+			})
+		}
+
+		snapshot[skn] = entries
+	}
+
+	return snapshot
+}
+
+// ClearIndex removes every entry from the secondary index with the given name
+// and strips the secondary key from every item, leaving the values in place.
+// It returns an error if the secondary key name does not exist.
+// Since Set always takes one secondary key per name, setting an item afterwards
+// indexes it under the cleared name again
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ClearIndex(skn SKNT) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	c.indexes[skn] = make(map[SKT]PKT)
+	for _, item := range c.values {
+		delete(item.secondaryKeys, skn)
+	}
+
+	return nil
+}
+
+// RemapSecondaryKeys replaces the secondary keys under the given name according to the mapping
+// from old to new key, updating the items holding them, and returns the number of changed items.
+// Old keys that are not in the cache are ignored. It returns an error if the name does not exist,
+// or if a new key would belong to a different pk, in which case nothing is changed
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) RemapSecondaryKeys(skn SKNT, mapping map[SKT]SKT) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return 0, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	// collect the keys to move with their normalized new keys, and the pk each new key will belong to
+	index := c.indexes[skn]
+	moved := make(map[SKT]PKT, len(mapping))
+	renamed := make(map[SKT]SKT, len(mapping))
+	targets := make(map[SKT]PKT, len(mapping))
+	for oldKey, newKey := range mapping {
+		oldKey, newKey = c.normalize(skn, oldKey), c.normalize(skn, newKey)
+		pk, ok := index[oldKey]
+		if !ok || oldKey == newKey {
+			continue
+		}
+
+		var zero SKT
+		if c.rejectZeroKeys && newKey == zero {
+			return 0, ErrZeroSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+
+		if tpk, ok := targets[newKey]; ok && tpk != pk {
+			return 0, ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: tpk, NewPK: pk}
+		}
+		moved[oldKey] = pk
+		renamed[oldKey] = newKey
+		targets[newKey] = pk
+	}
+
+	// check the new keys against the keys staying in place
+	now := c.now()
+	var expired []item[PKT, VT, SKNT, SKT]
+	for newKey, pk := range targets {
+		spk, ok := index[newKey]
+		if _, moving := moved[newKey]; !ok || moving || spk == pk {
+			continue
+		}
+		if owner := c.values[spk]; owner.expired(now) {
+			expired = append(expired, owner)
+			continue
+		}
+		return 0, ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: pk}
+	}
+
+	// drop the expired items holding any of the new keys
+	for _, owner := range expired {
+		c.evict(owner, EvictReasonExpired)
+	}
+
+	// move the keys, removing all the old ones first since keys may be swapped
+	for oldKey := range moved {
+		delete(index, oldKey)
+	}
+	for oldKey, pk := range moved {
+		newKey := renamed[oldKey]
+		index[newKey] = pk
+		c.values[pk].secondaryKeys[skn] = newKey
+	}
+
+	return len(moved), nil
+}
+
+// Filter returns a map of all the live items in the cache for which the predicate returns true.
+// The predicate is called while holding the read lock, so it must not modify the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Filter(pred func(pk PKT, v VT) bool) map[PKT]VT {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	values := make(map[PKT]VT)
+	for pk, item := range c.values {
+		if !item.live(now) {
+			continue
+		}
+		if v := c.read(item); pred(pk, v) {
+			values[pk] = v
+		}
+	}
+	return values
+}
+
+// DeleteWhere deletes all the live items for which the predicate returns true
+// and returns the number of deleted items.
+// The predicate is called while holding the write lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) DeleteWhere(pred func(pk PKT, v VT) bool) int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// collect the matching items before changing anything
+	now := c.now()
+	var matches []item[PKT, VT, SKNT, SKT]
+	for pk, item := range c.values {
+		if !item.live(now) {
+			continue
+		}
+
+		if pred(pk, c.read(item)) {
+			matches = append(matches, item)
+		}
+	}
+
+	// delete the items and their secondary keys
+	for _, item := range matches {
+		c.deleteItem(item)
+	}
+
+	return len(matches)
+}
+
+// UpdateMany replaces the values of the items with the given primary keys, leaving their
+// secondary keys untouched, and returns the number of updated items.
+// Primary keys that are not in the cache, or whose items are expired or reserved, are ignored
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) UpdateMany(values map[PKT]VT) int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.now()
+	n := 0
+	for pk, v := range values {
+		item, ok := c.values[pk]
+		if !ok || !item.live(now) {
+			continue
+		}
+
+		item.value = c.cloneValue(v)
+		item.version++
+		c.recost(&item)
+		c.values[pk] = item
+		c.emit(EventSet, item, 0)
+		c.countSets(1)
+		n++
+	}
+
+	// the new values may be more costly than the old ones
+	c.evictOverflow()
+
+	return n
+}
+
+// SecondaryKeyOf returns the secondary key under the given name of the item with the given
+// primary key and a boolean indicating if the item was found with such a key,
+// and returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeyOf(pk PKT, skn SKNT) (SKT, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var zero SKT
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		return zero, false, nil
+	}
+
+	sk, ok := item.secondaryKeys[skn]
+	return sk, ok, nil
+}
+
+// SecondaryKeysOf returns a copy of all the secondary keys of the item with the given primary key,
+// keyed by secondary key name, and a boolean indicating if the item was found
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SecondaryKeysOf(pk PKT) (map[SKNT]SKT, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		return nil, false
+	}
+
+	return copySecondaryKeys(item.secondaryKeys), true
+}
+
+// VerifyItem checks that every secondary key of the item with the given primary key
+// points back to it in the corresponding index and returns an error describing the first mismatch.
+// It returns nil if the item is consistent or absent
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) VerifyItem(pk PKT) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.values[pk]
+	if !ok {
+		return nil
+	}
+
+	return c.verifyItem(item)
+}
+
+// Verify checks that every secondary key of every item points back to it in the corresponding
+// index, and that every index entry points to an item having that secondary key.
+// It returns an error for every discrepancy found, or nil if the cache is consistent
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Verify() []error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var errs []error
+
+	// check the items against the indexes
+	for _, it := range c.values {
+		for _, skn := range c.secondaryKeyNames {
+			sk, ok := it.secondaryKeys[skn]
+			if !ok {
+				continue
+			}
+
+			ipk, ok := c.indexes[skn][sk]
+			if !ok || ipk != it.pk {
+				errs = append(errs, ErrInconsistentIndex[PKT, SKNT, SKT]{SecondaryKeyName: skn, SecondaryKey: sk, PK: it.pk, IndexedPK: ipk, Missing: !ok})
+			}
+		}
+	}
+
+	// check the indexes against the items
+	for _, skn := range c.secondaryKeyNames {
+		for sk, pk := range c.indexes[skn] {
+			it, ok := c.values[pk]
+			if !ok {
+				errs = append(errs, ErrOrphanedIndexEntry[PKT, SKNT, SKT]{SecondaryKeyName: skn, SecondaryKey: sk, IndexedPK: pk, Missing: true})
+				continue
+			}
+
+			if isk, ok := it.secondaryKeys[skn]; !ok || isk != sk {
+				errs = append(errs, ErrOrphanedIndexEntry[PKT, SKNT, SKT]{SecondaryKeyName: skn, SecondaryKey: sk, IndexedPK: pk})
+			}
+		}
+	}
+
+	return errs
+}
+
+// verifyItem checks the index entries of a single item.
+// The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) verifyItem(it item[PKT, VT, SKNT, SKT]) error {
+	for _, skn := range c.secondaryKeyNames {
+		sk, ok := it.secondaryKeys[skn]
+		if !ok {
+			continue
+		}
+
+		ipk, ok := c.indexes[skn][sk]
+		if !ok || ipk != it.pk {
+			return ErrInconsistentIndex[PKT, SKNT, SKT]{SecondaryKeyName: skn, SecondaryKey: sk, PK: it.pk, IndexedPK: ipk, Missing: !ok}
+		}
+	}
+
+	return nil
+}
+
+// ForEachSecondaryKey calls fn for every entry of the secondary index with the given name
+// until fn returns false, and returns an error if the secondary key name does not exist.
+// The function is called while holding the read lock, so it must not modify the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ForEachSecondaryKey(skn SKNT, fn func(sk SKT, pk PKT) bool) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	for sk, pk := range c.indexes[skn] {
+		if !fn(sk, pk) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// FindBySecondaryKey looks up the secondary key in each of the named indexes in order
+// and returns the value of the first item found together with the name of the index it was found in,
+// a boolean indicating if an item was found and an error if any of the secondary key names does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) FindBySecondaryKey(sk SKT, skns ...SKNT) (VT, SKNT, bool, error) {
+	var l lookups
+	defer c.observeLookups(&l)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var zero VT
+	var zeroName SKNT
+
+	// check if the secondary key names exist
+	for _, skn := range skns {
+		if !c.secondaryKeyNameExists(skn) {
+			return zero, zeroName, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+	}
+
+	now := c.now()
+	for _, skn := range skns {
+		pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+		if !ok {
+			continue
+		}
+
+		item, ok := c.values[pk]
+		if !ok || !item.live(now) {
+			continue
+		}
+
+		l.hits++
+		c.touch(item)
+		return c.read(item), skn, true, nil
+	}
+
+	l.misses++
+	return zero, zeroName, false, nil
+}
+
+// CompareAndDelete deletes the item with the given primary key only if its current value equals old
+// according to the equality of the cache, as with CompareAndSwap, and returns true if the item was deleted
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) CompareAndDelete(pk PKT, old VT) bool {
+	c.mu.Lock()
+	defer c.unlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) || !c.equal(item.value, old) {
+		return false
+	}
+
+	c.deleteItem(item)
+
+	return true
+}
+
+// Number is the constraint of the value types supported by Increment
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Increment adds delta to the value of the item with the given primary key under the write lock,
+// leaving its secondary keys untouched, and returns the new value and a boolean indicating
+// if the item was found. Absent items are not created, since they would lack secondary keys.
+// It is a function rather than a method since it requires a numeric value type
+func Increment[PKT comparable, VT Number, SKNT comparable, SKT comparable](c *multiKeyCache[PKT, VT, SKNT, SKT], pk PKT, delta VT) (VT, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		return 0, false
+	}
+
+	item.value += delta
+	item.version++
+	c.recost(&item)
+	c.values[pk] = item
+	c.emit(EventSet, item, 0)
+	c.countSets(1)
+
+	// the new value may be more costly than the old one
+	c.evictOverflow()
+
+	return c.read(item), true
+}
+
+// SetWithVersion works like Set, but only stores the item if its current version equals expected,
+// for optimistic concurrency. The version of a pk that is not in the cache is 0, and every change
+// of the value increments it. It returns the new version, or ErrVersionMismatch if the version
+// has changed since it was read, e.g. with GetWithVersion
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetWithVersion(pk PKT, v VT, expected uint64, sKeys ...SKT) (uint64, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	var actual uint64
+	if item, ok := c.values[pk]; ok && item.live(c.now()) {
+		actual = item.version
+	}
+	if actual != expected {
+		return actual, ErrVersionMismatch[PKT]{PK: pk, Expected: expected, Actual: actual}
+	}
+
+	_, it, _, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+	if err != nil {
+		return actual, err
+	}
+
+	return it.version, nil
+}
+
+// GetWithVersion works like Get, but also returns the current version of the item,
+// to be passed to SetWithVersion
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetWithVersion(pk PKT) (VT, uint64, bool) {
+	var l lookups
+	defer c.observeLookups(&l)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		l.misses++
+		var v VT
+		return v, 0, false
+	}
+
+	l.hits++
+	c.touch(item)
+	return c.read(item), item.version, true
+}
+
+// CompareAndSwap replaces the value of the item with the given primary key with new,
+// leaving its secondary keys untouched, only if its current value equals old
+// according to the equality of the cache. It returns true if the value was replaced
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) CompareAndSwap(pk PKT, old, new VT) bool {
+	c.mu.Lock()
+	defer c.unlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) || !c.equal(item.value, old) {
+		return false
+	}
+
+	item.value = c.cloneValue(new)
+	item.version++
+	c.recost(&item)
+	c.values[pk] = item
+	c.emit(EventSet, item, 0)
+	c.countSets(1)
+
+	// the new value may be more costly than the old one
+	c.evictOverflow()
+
+	return true
+}
+
+// Mutate replaces the value of the item with the given primary key with the result of fn applied
+// to its current value, leaving its secondary keys untouched, as a single atomic step.
+// It returns the new value and true, or false if the item is not in the cache.
+// The function is called while holding the write lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Mutate(pk PKT, fn func(old VT) VT) (VT, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	item, ok := c.values[pk]
+	if !ok || !item.live(c.now()) {
+		var zero VT
+		return zero, false
+	}
+
+	item.value = c.cloneValue(fn(c.cloneValue(item.value)))
+	item.version++
+	c.recost(&item)
+	c.values[pk] = item
+	c.emit(EventSet, item, 0)
+	c.countSets(1)
+	v := c.read(item)
+
+	// the new value may be more costly than the old one
+	c.evictOverflow()
+
+	return v, true
+}
+
+// IndexEntry is a single secondary index entry, mapping a secondary key under a name to a primary key
+type IndexEntry[SKNT comparable, SKT comparable, PKT comparable] struct {
+	SecondaryKeyName SKNT
+	SecondaryKey     SKT
+	PK               PKT
+}
+
+// IndexEntries returns every entry of every secondary index, grouped by secondary key name
+// in the order of the secondary key names
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) IndexEntries() []IndexEntry[SKNT, SKT, PKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := 0
+	for _, index := range c.indexes {
+		n += len(index)
+	}
+
+	entries := make([]IndexEntry[SKNT, SKT, PKT], 0, n)
+	for _, skn := range c.secondaryKeyNames {
+		for sk, pk := range c.indexes[skn] {
+			entries = append(entries, IndexEntry[SKNT, SKT, PKT]{SecondaryKeyName: skn, SecondaryKey: sk, PK: pk})
+		}
+	}
+	return entries
+}
+
+// AddSecondaryKeyName adds a new secondary key name to the cache and builds its index
+// by calling keyFor for every item already in the cache. Afterwards Set expects one more
+// secondary key, in the position of the new name after all the existing ones.
+// It returns an error if the secondary key name already exists, if it exceeds the limit set by
+// WithMaxSecondaryKeyNames, if keyFor returns the same secondary key for different items,
+// or the zero value with WithRejectZeroSecondaryKeys, in which case the cache is left unchanged
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) AddSecondaryKeyName(skn SKNT, keyFor func(pk PKT, v VT) SKT) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// check if the secondary key name is unique
+	if c.secondaryKeyNameExists(skn) {
+		return ErrSecondaryKeyNameNotUnique[SKNT]{SecondaryKeyName: skn}
+	}
+
+	// check if the number of secondary key names is within the limit
+	if err := c.checkNameCount(len(c.secondaryKeyNames) + 1); err != nil {
+		return err
+	}
+
+	// build the new index before changing anything
+	var zero SKT
+	index := make(map[SKT]PKT, len(c.values))
+	for pk, item := range c.values {
+		sk := c.normalize(skn, keyFor(pk, item.value))
+		if c.rejectZeroKeys && sk == zero {
+			return ErrZeroSecondaryKey[SKNT]{SecondaryKeyName: skn}
+		}
+		if spk, ok := index[sk]; ok {
+			return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: pk}
+		}
+		index[sk] = pk
+	}
+
+	for sk, pk := range index {
+		c.values[pk].secondaryKeys[skn] = sk
+	}
+
+	c.indexes[skn] = index
+	c.secondaryKeyNames = append(slices.Clip(c.secondaryKeyNames), skn)
+
+	return nil
+}
+
+// RemoveSecondaryKeyName drops the secondary index with the given name entirely
+// and removes the secondary key from every item. Afterwards Set expects one fewer secondary key.
+// It returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) RemoveSecondaryKeyName(skn SKNT) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	for _, item := range c.values {
+		delete(item.secondaryKeys, skn)
+	}
+
+	delete(c.indexes, skn)
+	c.secondaryKeyNames = slices.DeleteFunc(slices.Clone(c.secondaryKeyNames), func(n SKNT) bool { return n == skn })
+
+	return nil
+}
+
+// ItemView is a copy of an item's value together with its secondary keys
+type ItemView[VT any, SKNT comparable, SKT comparable] struct {
+	Value         VT
+	SecondaryKeys map[SKNT]SKT
+}
+
+// GetAllWithKeys returns a map of all the live items in the cache including their secondary keys.
+// The secondary key maps are copies, so they can be changed freely
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetAllWithKeys() map[PKT]ItemView[VT, SKNT, SKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	views := make(map[PKT]ItemView[VT, SKNT, SKT], len(c.values))
+	for pk, item := range c.values {
+		if !item.live(now) {
+			continue
+		}
+		views[pk] = ItemView[VT, SKNT, SKT]{
+			Value:         c.read(item),
+			SecondaryKeys: copySecondaryKeys(item.secondaryKeys),
+		}
+	}
+	return views
+}
+
+// KeysBySecondaryKeyFunc returns the primary keys of all the live items whose secondary key
+// under the given name satisfies match, and returns an error if the secondary key name does not exist.
+// The match function is called while holding the read lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) KeysBySecondaryKeyFunc(skn SKNT, match func(sk SKT) bool) ([]PKT, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return nil, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	now := c.now()
+	keys := []PKT{}
+	for sk, pk := range c.indexes[skn] {
+		if match(sk) && c.values[pk].live(now) {
+			keys = append(keys, pk)
+		}
+	}
+
+	return keys, nil
+}
+
+// DeleteBySecondaryKeyFunc deletes all the live items whose secondary key under the given name
+// satisfies match, e.g. all keys sharing a prefix, and returns the number of deleted items
+// and an error if the secondary key name does not exist.
+// The match function is called while holding the write lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) DeleteBySecondaryKeyFunc(skn SKNT, match func(sk SKT) bool) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return 0, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	// collect the matching items before changing anything
+	now := c.now()
+	var matches []item[PKT, VT, SKNT, SKT]
+	for sk, pk := range c.indexes[skn] {
+		if item, ok := c.values[pk]; ok && item.live(now) && match(sk) {
+			matches = append(matches, item)
+		}
+	}
+
+	// delete the items and their secondary keys
+	for _, item := range matches {
+		c.deleteItem(item)
+	}
+
+	return len(matches), nil
+}
+
+// defaultEqual compares values with reflect.DeepEqual
+func defaultEqual[VT any](a, b VT) bool {
+	return reflect.DeepEqual(a, b)
+}
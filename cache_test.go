@@ -1,7 +1,13 @@
 package multikeycache
 
 import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -102,9 +108,9 @@ func TestMultiKeyCache(t *testing.T) {
 	assert.Equal(t, []string{"a", "b", "c"}, c.SecondaryKeyNames())
 
 	// check the secondary keys
-	assert.Equal(t, []string{"a5", "a6"}, c.SecondaryKeys("a"))
-	assert.Equal(t, []string{"b5", "b6"}, c.SecondaryKeys("b"))
-	assert.Equal(t, []string{"c5", "c6"}, c.SecondaryKeys("c"))
+	assert.ElementsMatch(t, []string{"a5", "a6"}, c.SecondaryKeys("a"))
+	assert.ElementsMatch(t, []string{"b5", "b6"}, c.SecondaryKeys("b"))
+	assert.ElementsMatch(t, []string{"c5", "c6"}, c.SecondaryKeys("c"))
 
 	// check the secondary key name to keys map
 	assert.Equal(t, map[string]string{"a5": "pk5", "a6": "pk6"}, c.SecondaryKeyNameToKeys("a"))
@@ -121,3 +127,1852 @@ func TestMultiKeyCache(t *testing.T) {
 	// check the length of the cache
 	assert.Equal(t, 0, c.Len())
 }
+
+func TestFromSlice(t *testing.T) {
+	type row struct {
+		id       int
+		name     string
+		email    string
+		username string
+	}
+	extract := func(r row) (int, string, []string) {
+		return r.id, r.name, []string{r.email, r.username}
+	}
+
+	// a clean build sets every row
+	rows := []row{
+		{1, "John", "john@example.com", "john123"},
+		{2, "Jane", "jane@example.com", "jane123"},
+	}
+	c, err := FromSlice(rows, []string{"email", "username"}, extract)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "John", 2: "Jane"}, c.GetAll())
+	value, ok, err := c.GetBySecondaryKey("username", "jane123")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Jane", value)
+
+	// the options are applied
+	c, err = FromSlice(rows, []string{"email", "username"}, extract, WithMaxEntries[int, string, string, string](1))
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{2: "Jane"}, c.GetAll())
+
+	// a conflict between rows returns the error
+	rows = append(rows, row{3, "Jim", "jim@example.com", "john123"})
+	c, err = FromSlice(rows, []string{"email", "username"}, extract)
+	assert.Equal(t, ErrWrongSecondaryKey[int, string]{SecondaryKey: "username", ExistingPK: 1, NewPK: 3}, err)
+	assert.Nil(t, c)
+
+	// and so do duplicate names
+	_, err = FromSlice(rows, []string{"email", "email"}, extract)
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNameNotUnique[string]{})
+}
+
+func TestErrorKinds(t *testing.T) {
+	// errors of the same type match their kind regardless of the type parameters
+	c1, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	c2, err := NewMultiKeyCache[int, int, int, int]([]int{1})
+	assert.Nil(t, err)
+	_, _, err1 := c1.GetBySecondaryKey("b", "b1")
+	_, _, err2 := c2.GetBySecondaryKey(2, 1)
+	assert.True(t, errors.Is(err1, ErrUnknownSecondaryKeyKind))
+	assert.True(t, errors.Is(err2, ErrUnknownSecondaryKeyKind))
+	assert.False(t, errors.Is(err1, ErrWrongSecondaryKeyKind))
+
+	// also when wrapped
+	assert.Nil(t, c1.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c2.Set(1, 1, 1))
+	err1 = fmt.Errorf("storing user: %w", c1.Set("pk2", "value2", "a1"))
+	err2 = fmt.Errorf("storing user: %w", c2.Set(2, 2, 1))
+	assert.True(t, errors.Is(err1, ErrWrongSecondaryKeyKind))
+	assert.True(t, errors.Is(err2, ErrWrongSecondaryKeyKind))
+
+	// and for every error type
+	kinds := map[error]error{
+		ErrSecondaryKeyNumberMismatch{}:              ErrSecondaryKeyNumberMismatchKind,
+		ErrTooManySecondaryKeyNames{}:                ErrTooManySecondaryKeyNamesKind,
+		ErrMissingSecondaryKey[int]{}:                ErrMissingSecondaryKeyKind,
+		ErrPrimaryKeyExists[int]{}:                   ErrPrimaryKeyExistsKind,
+		ErrPrimaryKeyNotFound[string]{}:              ErrPrimaryKeyNotFoundKind,
+		ErrZeroSecondaryKey[string]{}:                ErrZeroSecondaryKeyKind,
+		ErrSecondaryKeyNameNotUnique[int]{}:          ErrSecondaryKeyNameNotUniqueKind,
+		ErrInconsistentIndex[int, string, string]{}:  ErrInconsistentIndexKind,
+		ErrOrphanedIndexEntry[string, int, string]{}: ErrOrphanedIndexEntryKind,
+		ErrVersionMismatch[int]{}:                    ErrVersionMismatchKind,
+		ErrLoadedKeyMismatch[int, string, string]{}:  ErrLoadedKeyMismatchKind,
+	}
+	for err, kind := range kinds {
+		assert.True(t, errors.Is(err, kind), err.Error())
+		assert.False(t, errors.Is(err, ErrUnknownSecondaryKeyKind), err.Error())
+	}
+	assert.True(t, errors.Is(ErrSecondaryKeyNamesMismatch[string]{Expected: []string{"a"}}, ErrSecondaryKeyNamesMismatchKind))
+	assert.True(t, errors.Is(ErrInconsistentSecondaryKeys[int, int]{PrimaryKeys: map[int]int{1: 1}}, ErrInconsistentSecondaryKeysKind))
+}
+
+func TestIndexDiff(t *testing.T) {
+	c1, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	c2, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// identical contents in both caches
+	assert.Nil(t, c1.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c2.Set("pk1", "value", "a1", "b1"))
+
+	// identical caches have no differences
+	assert.Empty(t, c1.IndexDiff(c2))
+	assert.Empty(t, c1.IndexDiff(c1))
+
+	// an item only present in the first cache
+	assert.Nil(t, c1.Set("pk2", "value", "a2", "b2"))
+
+	// an item only present in the second cache
+	assert.Nil(t, c2.Set("pk3", "value", "a3", "b3"))
+
+	// the same secondary key pointing to different primary keys
+	assert.Nil(t, c1.Set("pk4", "value", "a4", "b4"))
+	assert.Nil(t, c2.Set("pk5", "value", "a5", "b4"))
+
+	diff := c1.IndexDiff(c2)
+	assert.Len(t, diff, 2)
+	assert.ElementsMatch(t, []string{"a2", "a4"}, diff["a"].OnlyHere)
+	assert.ElementsMatch(t, []string{"a3", "a5"}, diff["a"].OnlyThere)
+	assert.ElementsMatch(t, []string{"b2", "b4"}, diff["b"].OnlyHere)
+	assert.ElementsMatch(t, []string{"b3", "b4"}, diff["b"].OnlyThere)
+
+	// the reverse comparison swaps the sides
+	reverse := c2.IndexDiff(c1)
+	assert.ElementsMatch(t, diff["a"].OnlyHere, reverse["a"].OnlyThere)
+	assert.ElementsMatch(t, diff["a"].OnlyThere, reverse["a"].OnlyHere)
+}
+
+func TestDiff(t *testing.T) {
+	c1, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	c2, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// identical caches have no differences
+	assert.Nil(t, c1.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c2.Set("pk1", "value1", "a1"))
+	added, removed, changed, err := c1.Diff(c2)
+	assert.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+
+	// an item only in the other cache, one only in this cache, and one with a different value
+	assert.Nil(t, c2.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c1.Set("pk3", "value3", "a3"))
+	assert.Nil(t, c1.Set("pk4", "value4", "a4"))
+	assert.Nil(t, c2.Set("pk4", "changed", "a4"))
+	added, removed, changed, err = c1.Diff(c2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pk2"}, added)
+	assert.Equal(t, []string{"pk3"}, removed)
+	assert.Equal(t, []string{"pk4"}, changed)
+
+	// the reverse comparison swaps added and removed
+	added, removed, changed, err = c2.Diff(c1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pk3"}, added)
+	assert.Equal(t, []string{"pk2"}, removed)
+	assert.Equal(t, []string{"pk4"}, changed)
+
+	// different secondary key names
+	c3, err := NewMultiKeyCache[string, string, string, string]([]string{"b"})
+	assert.Nil(t, err)
+	_, _, _, err = c1.Diff(c3)
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNamesMismatch[string]{})
+}
+
+func TestClone(t *testing.T) {
+	c, err := NewMultiKeyCache[string, []string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", []string{"value1"}, "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", []string{"value2"}, "a2", "b2"))
+
+	// clone the cache
+	clone := c.Clone()
+	assert.Equal(t, c.GetAll(), clone.GetAll())
+	assert.Empty(t, c.IndexDiff(clone))
+
+	// mutate the clone
+	clone.Delete("pk1")
+	assert.Nil(t, clone.Set("pk2", []string{"changed"}, "a2", "b2"))
+	assert.Nil(t, clone.Set("pk3", []string{"value3"}, "a3", "b3"))
+	clone.values["pk2"].secondaryKeys["a"] = "tampered"
+
+	// the original is untouched
+	assert.Equal(t, map[string][]string{"pk1": {"value1"}, "pk2": {"value2"}}, c.GetAll())
+	value, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"value1"}, value)
+	_, ok, err = c.GetBySecondaryKey("a", "a3")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "a2", c.values["pk2"].secondaryKeys["a"])
+
+	// values are copied shallowly, so the clone shares slice contents with the original
+	value, _ = c.Clone().Get("pk2")
+	value[0] = "mutated"
+	shared, _ := c.Get("pk2")
+	assert.Equal(t, []string{"mutated"}, shared)
+}
+
+func TestMerge(t *testing.T) {
+	c1, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c1.Set("pk2", "value2", "a2", "b2"))
+
+	c2, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c2.Set("pk2", "changed", "a2", "b9"))
+	assert.Nil(t, c2.Set("pk3", "value3", "a3", "b3"))
+
+	// merge caches with matching secondary key names
+	assert.Nil(t, c1.Merge(c2))
+	assert.Equal(t, map[string]string{"pk1": "value1", "pk2": "changed", "pk3": "value3"}, c1.GetAll())
+
+	// the overwritten item is indexed by its new secondary keys only
+	value, ok, err := c1.GetBySecondaryKey("b", "b9")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", value)
+	_, ok, err = c1.GetBySecondaryKey("b", "b2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the other cache is untouched
+	assert.Equal(t, 2, c2.Len())
+
+	// merge caches with secondary key names in a different order
+	c3, err := NewMultiKeyCache[string, string, string, string]([]string{"b", "a"})
+	assert.Nil(t, err)
+	err = c1.Merge(c3)
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNamesMismatch[string]{})
+
+	// merge caches with conflicting secondary keys
+	c4, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c4.Set("pk4", "value4", "a4", "b4"))
+	assert.Nil(t, c4.Set("pk5", "value5", "a5", "b1"))
+	err = c1.Merge(c4)
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{SecondaryKey: "b", ExistingPK: "pk1", NewPK: "pk5"})
+
+	// a failed merge leaves the receiver unchanged
+	assert.Equal(t, map[string]string{"pk1": "value1", "pk2": "changed", "pk3": "value3"}, c1.GetAll())
+	_, ok, err = c1.GetBySecondaryKey("a", "a4")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestUnindexedPrimaryKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+
+	// a consistent cache has no unindexed keys
+	assert.Empty(t, c.UnindexedPrimaryKeys())
+
+	// removing a single index entry still leaves the item referenced
+	delete(c.indexes["a"], "a1")
+	assert.Empty(t, c.UnindexedPrimaryKeys())
+
+	// removing all index entries leaves the item unreferenced
+	delete(c.indexes["b"], "b1")
+	assert.Equal(t, []string{"pk1"}, c.UnindexedPrimaryKeys())
+}
+
+func TestUnindexedKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"}, WithPartialSecondaryKeys[string, string, string, string]())
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1", "c1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+	assert.Nil(t, c.Set("pk3", "value", "a3"))
+
+	// a fully indexed name has no unindexed keys
+	keys, err := c.UnindexedKeys("a")
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	// the partially indexed items are missing from the later indexes
+	keys, err = c.UnindexedKeys("b")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pk3"}, keys)
+	keys, err = c.UnindexedKeys("c")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"pk2", "pk3"}, keys)
+
+	// a corrupted index entry counts as missing
+	delete(c.indexes["a"], "a1")
+	keys, err = c.UnindexedKeys("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pk1"}, keys)
+
+	// an unknown secondary key name
+	_, err = c.UnindexedKeys("d")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "d"})
+}
+
+func TestSetReturning(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// insert a new item
+	oldKeys, newKeys, existed, err := c.SetReturning("pk1", "value", "a1", "b1")
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	assert.Nil(t, oldKeys)
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, newKeys)
+
+	// overwrite the item with a changed secondary key
+	oldKeys, newKeys, existed, err = c.SetReturning("pk1", "changed", "a1", "b2")
+	assert.Nil(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, oldKeys)
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b2"}, newKeys)
+
+	// the stale index entry is gone
+	_, ok, err := c.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	value, ok, err := c.GetBySecondaryKey("b", "b2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", value)
+
+	// the freed secondary key can be used by another item
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b1"))
+
+	// a failed set returns the error and nothing else
+	oldKeys, newKeys, existed, err = c.SetReturning("pk3", "value", "a1", "b3")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+	assert.False(t, existed)
+	assert.Nil(t, oldKeys)
+	assert.Nil(t, newKeys)
+}
+
+func TestReindex(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+
+	// corrupt the indexes
+	delete(c.indexes["a"], "a1")
+	c.indexes["b"]["b2"] = "pk1"
+	c.indexes["b"]["b3"] = "pk3"
+
+	// reindexing restores them
+	assert.Nil(t, c.Reindex())
+	assert.Equal(t, map[string]string{"a1": "pk1", "a2": "pk2"}, c.SecondaryKeyNameToKeys("a"))
+	assert.Equal(t, map[string]string{"b1": "pk1", "b2": "pk2"}, c.SecondaryKeyNameToKeys("b"))
+
+	// corrupt an item so two items share a secondary key
+	c.values["pk2"].secondaryKeys["a"] = "a1"
+
+	// reindexing reports the conflict
+	err = c.Reindex()
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+
+	// and leaves the indexes as they were
+	assert.Equal(t, map[string]string{"a1": "pk1", "a2": "pk2"}, c.SecondaryKeyNameToKeys("a"))
+}
+
+func TestGetByKeyCombination(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1", "c1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2", "c2"))
+
+	// a consistent set of keys
+	value, ok, err := c.GetByKeyCombination(map[string]string{"a": "a1", "c": "c1"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// a set of keys where one is not indexed
+	value, ok, err = c.GetByKeyCombination(map[string]string{"a": "a1", "b": "b9"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", value)
+
+	// an inconsistent set of keys
+	_, ok, err = c.GetByKeyCombination(map[string]string{"a": "a1", "b": "b2"})
+	assert.False(t, ok)
+	assert.ErrorAs(t, err, &ErrInconsistentSecondaryKeys[string, string]{})
+	assert.Equal(t, map[string]string{"a": "pk1", "b": "pk2"}, err.(ErrInconsistentSecondaryKeys[string, string]).PrimaryKeys)
+
+	// an unknown secondary key name
+	_, ok, err = c.GetByKeyCombination(map[string]string{"a": "a1", "d": "d1"})
+	assert.False(t, ok)
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "d"})
+}
+
+func TestCountBySecondaryKeyName(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// an empty index
+	n, err := c.CountBySecondaryKeyName("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// a populated index
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+	n, err = c.CountBySecondaryKeyName("b")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// an unknown secondary key name
+	n, err = c.CountBySecondaryKeyName("c")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+	assert.Equal(t, 0, n)
+}
+
+func TestCountConsistent(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// empty and populated caches are consistent
+	assert.True(t, c.CountConsistent())
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+	assert.True(t, c.CountConsistent())
+	n, err := c.Count("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// a lost index entry is detected
+	delete(c.indexes["b"], "b1")
+	assert.False(t, c.CountConsistent())
+	n, err = c.Count("b")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// and so is a stale one
+	assert.Nil(t, c.Reindex())
+	assert.True(t, c.CountConsistent())
+	c.indexes["a"]["a9"] = "pk9"
+	assert.False(t, c.CountConsistent())
+
+	// an unknown secondary key name
+	_, err = c.Count("c")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, int]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", 3, 30))
+	assert.Nil(t, c.Set("pk2", "value", 1, 10))
+	assert.Nil(t, c.Set("pk3", "value", 2, 20))
+
+	less := map[string]func(a, b int) bool{
+		"a": func(a, b int) bool { return a < b },
+		"b": func(a, b int) bool { return a > b },
+	}
+
+	// the entries are sorted by the comparators
+	snapshot := c.IndexSnapshot(less)
+	assert.Equal(t, []IndexSnapshotEntry[int, string]{{1, "pk2"}, {2, "pk3"}, {3, "pk1"}}, snapshot["a"])
+	assert.Equal(t, []IndexSnapshotEntry[int, string]{{30, "pk1"}, {20, "pk3"}, {10, "pk2"}}, snapshot["b"])
+
+	// the result is the same on every call
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, snapshot, c.IndexSnapshot(less))
+	}
+
+	// names without a comparator are still included
+	snapshot = c.IndexSnapshot(nil)
+	assert.ElementsMatch(t, []IndexSnapshotEntry[int, string]{{1, "pk2"}, {2, "pk3"}, {3, "pk1"}}, snapshot["a"])
+	assert.Len(t, snapshot["b"], 3)
+}
+
+func TestClearIndex(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+
+	// clear one index
+	assert.Nil(t, c.ClearIndex("a"))
+
+	// the index is empty and lookups by that name miss
+	assert.Empty(t, c.SecondaryKeyNameToKeys("a"))
+	_, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the values and the other index are intact
+	assert.Equal(t, map[string]string{"pk1": "value1", "pk2": "value2"}, c.GetAll())
+	value, ok, err := c.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	assert.Empty(t, c.UnindexedPrimaryKeys())
+
+	// the stripped key is no longer part of the item
+	assert.Equal(t, map[string]string{"b": "b1"}, c.values["pk1"].secondaryKeys)
+
+	// deleting an item only touches the keys it still has
+	assert.Nil(t, c.Set("pk3", "value3", "a1", "b3"))
+	c.Delete("pk1")
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value3", value)
+
+	// setting an item again indexes it under the cleared name
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+	value, ok, err = c.GetBySecondaryKey("a", "a2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+
+	// an unknown secondary key name
+	err = c.ClearIndex("c")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+}
+
+func TestFilter(t *testing.T) {
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", 1, "a1"))
+	assert.Nil(t, c.Set("pk2", 2, "a2"))
+	assert.Nil(t, c.Set("pk3", 3, "a3"))
+
+	// a predicate matching some items
+	values := c.Filter(func(pk string, v int) bool { return v%2 == 1 })
+	assert.Equal(t, map[string]int{"pk1": 1, "pk3": 3}, values)
+
+	// a predicate matching all items
+	assert.Equal(t, c.GetAll(), c.Filter(func(pk string, v int) bool { return true }))
+
+	// a predicate matching no items
+	assert.Empty(t, c.Filter(func(pk string, v int) bool { return false }))
+
+	// the result is a fresh copy
+	values["pk1"] = 42
+	delete(values, "pk3")
+	value, _ := c.Get("pk1")
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 3, c.Len())
+}
+
+func TestDeleteWhere(t *testing.T) {
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", 1, "a1"))
+	assert.Nil(t, c.Set("pk2", 2, "a2"))
+	assert.Nil(t, c.Set("pk3", 3, "a3"))
+
+	// a predicate matching no items
+	assert.Equal(t, 0, c.DeleteWhere(func(pk string, v int) bool { return false }))
+	assert.Equal(t, 3, c.Len())
+
+	// a predicate matching some items
+	assert.Equal(t, 2, c.DeleteWhere(func(pk string, v int) bool { return v%2 == 1 }))
+	assert.Equal(t, map[string]int{"pk2": 2}, c.GetAll())
+
+	// the secondary keys of the deleted items are gone
+	assert.Equal(t, map[string]string{"a2": "pk2"}, c.SecondaryKeyNameToKeys("a"))
+
+	// an empty cache
+	c.Clear()
+	assert.Equal(t, 0, c.DeleteWhere(func(pk string, v int) bool { return true }))
+}
+
+func TestUpdateMany(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+
+	// update two existing items and a missing one
+	n := c.UpdateMany(map[string]string{"pk1": "changed1", "pk3": "changed3", "pk4": "value4"})
+	assert.Equal(t, 2, n)
+
+	// only the existing items were updated
+	assert.Equal(t, map[string]string{"pk1": "changed1", "pk2": "value2", "pk3": "changed3"}, c.GetAll())
+
+	// the secondary keys are untouched
+	value, ok, err := c.GetBySecondaryKey("a", "a3")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed3", value)
+	assert.Equal(t, map[string]string{"a1": "pk1", "a2": "pk2", "a3": "pk3"}, c.SecondaryKeyNameToKeys("a"))
+
+	// expired and reserved items are not updated
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err = NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value1", time.Second, "a1"))
+	assert.Nil(t, c.Reserve("pk2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	clock.Advance(time.Second)
+	n = c.UpdateMany(map[string]string{"pk1": "changed1", "pk2": "changed2", "pk3": "changed3"})
+	assert.Equal(t, 1, n)
+	assert.Equal(t, map[string]string{"pk3": "changed3"}, c.GetAll())
+}
+
+func TestVerifyItem(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+
+	// consistent and absent items
+	assert.Nil(t, c.VerifyItem("pk1"))
+	assert.Nil(t, c.VerifyItem("pk3"))
+
+	// an index entry pointing to a different pk
+	c.indexes["b"]["b1"] = "pk2"
+	err = c.VerifyItem("pk1")
+	assert.Equal(t, ErrInconsistentIndex[string, string, string]{SecondaryKeyName: "b", SecondaryKey: "b1", PK: "pk1", IndexedPK: "pk2"}, err)
+	assert.EqualError(t, err, "secondary key b1 of pk pk1 points to pk pk2 in index b")
+
+	// a missing index entry
+	delete(c.indexes["a"], "a2")
+	err = c.VerifyItem("pk2")
+	assert.Equal(t, ErrInconsistentIndex[string, string, string]{SecondaryKeyName: "a", SecondaryKey: "a2", PK: "pk2", Missing: true}, err)
+	assert.EqualError(t, err, "secondary key a2 of pk pk2 is missing from index a")
+}
+
+func TestVerify(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+
+	// a consistent cache
+	assert.Nil(t, c.Verify())
+
+	// an index entry pointing to a different pk is reported from both sides
+	c.indexes["b"]["b1"] = "pk2"
+	assert.ElementsMatch(t, []error{
+		ErrInconsistentIndex[string, string, string]{SecondaryKeyName: "b", SecondaryKey: "b1", PK: "pk1", IndexedPK: "pk2"},
+		ErrOrphanedIndexEntry[string, string, string]{SecondaryKeyName: "b", SecondaryKey: "b1", IndexedPK: "pk2"},
+	}, c.Verify())
+	c.indexes["b"]["b1"] = "pk1"
+
+	// a missing index entry
+	delete(c.indexes["a"], "a2")
+	assert.Equal(t, []error{ErrInconsistentIndex[string, string, string]{SecondaryKeyName: "a", SecondaryKey: "a2", PK: "pk2", Missing: true}}, c.Verify())
+	c.indexes["a"]["a2"] = "pk2"
+
+	// an index entry pointing to a missing pk
+	c.indexes["a"]["a3"] = "pk3"
+	errs := c.Verify()
+	assert.Equal(t, []error{ErrOrphanedIndexEntry[string, string, string]{SecondaryKeyName: "a", SecondaryKey: "a3", IndexedPK: "pk3", Missing: true}}, errs)
+	assert.EqualError(t, errs[0], "secondary key a3 in index a points to missing pk pk3")
+	delete(c.indexes["a"], "a3")
+
+	// the cache is consistent again
+	assert.Nil(t, c.Verify())
+}
+
+func BenchmarkParallelGetSet(b *testing.B) {
+	c, err := NewMultiKeyCache[int, int, string, int]([]string{"a"})
+	assert.Nil(b, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(b, c.Set(i, i, i))
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			// nine reads for every write
+			if i%10 == 0 {
+				_ = c.Set(i%1000, i, i%1000)
+			} else {
+				c.Get(i % 1000)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	const n = 100000
+
+	load := func(b *testing.B, opts ...Option[int, int, string, int]) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, err := NewMultiKeyCache[int, int, string, int]([]string{"a", "b"}, opts...)
+			assert.Nil(b, err)
+			for j := 0; j < n; j++ {
+				_ = c.Set(j, j, j, -j)
+			}
+		}
+	}
+
+	b.Run("default", func(b *testing.B) {
+		load(b)
+	})
+
+	// presized maps never grow during the load
+	b.Run("presized", func(b *testing.B) {
+		load(b, WithInitialCapacity[int, int, string, int](n))
+	})
+}
+
+type benchValue struct {
+	ID   int
+	Data [4096]byte
+}
+
+// benchmarkGet measures Get on a cache of 1000 items made by newValue
+func benchmarkGet[VT any](b *testing.B, c *multiKeyCache[int, VT, string, int], newValue func(i int) VT) {
+	for i := 0; i < 1000; i++ {
+		assert.Nil(b, c.Set(i, newValue(i), i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(i % 1000)
+	}
+}
+
+func BenchmarkLargeValues(b *testing.B) {
+	// large values are copied in and out of the cache
+	b.Run("value", func(b *testing.B) {
+		c, err := NewMultiKeyCache[int, benchValue, string, int]([]string{"a"})
+		assert.Nil(b, err)
+		benchmarkGet(b, c, func(i int) benchValue { return benchValue{ID: i} })
+	})
+
+	// pointers are not, but the stored values are shared with the callers
+	b.Run("pointer", func(b *testing.B) {
+		c, err := NewMultiKeyCache[int, *benchValue, string, int]([]string{"a"})
+		assert.Nil(b, err)
+		benchmarkGet(b, c, func(i int) *benchValue { return &benchValue{ID: i} })
+	})
+
+	// a cloner keeps them apart at the cost of a copy per read
+	b.Run("pointer with cloner", func(b *testing.B) {
+		clone := func(v *benchValue) *benchValue {
+			c := *v
+			return &c
+		}
+		c, err := NewMultiKeyCache[int, *benchValue, string, int]([]string{"a"}, WithValueCloner[int, *benchValue, string, int](clone))
+		assert.Nil(b, err)
+		benchmarkGet(b, c, func(i int) *benchValue { return &benchValue{ID: i} })
+	})
+}
+
+func TestTrim(t *testing.T) {
+	c, err := NewMultiKeyCache[int, string, string, int]([]string{"a", "b"})
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, c.Set(i, fmt.Sprint("value", i), i, -i))
+	}
+	c.DeleteWhere(func(pk int, v string) bool { return pk%100 != 0 })
+	before := c.GetAll()
+	order := c.ExportRecords()
+
+	// the contents, the indexes and the recency are preserved
+	c.Trim()
+	assert.Equal(t, before, c.GetAll())
+	assert.Equal(t, order, c.ExportRecords())
+	assert.Empty(t, c.Verify())
+	value, ok, err := c.GetBySecondaryKey("b", -500)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value500", value)
+	assert.Equal(t, 10, c.EvictOldest(10))
+	assert.Equal(t, 0, c.Len())
+
+	// the trimmed cache keeps working
+	assert.Nil(t, c.Set(1, "value1", 1, -1))
+	assert.ErrorAs(t, c.Set(2, "value2", 1, -2), &ErrWrongSecondaryKey[int, string]{})
+}
+
+func BenchmarkTrim(b *testing.B) {
+	const n = 100000
+
+	// retained measures the heap in use after loading n items and deleting all but a few of them
+	retained := func(b *testing.B, trim bool) {
+		for i := 0; i < b.N; i++ {
+			c, err := NewMultiKeyCache[int, int, string, int]([]string{"a", "b"})
+			assert.Nil(b, err)
+			for j := 0; j < n; j++ {
+				_ = c.Set(j, j, j, -j)
+			}
+			c.DeleteWhere(func(pk int, v int) bool { return pk%1000 != 0 })
+			if trim {
+				c.Trim()
+			}
+
+			var m runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&m)
+			b.ReportMetric(float64(m.HeapInuse), "retained-bytes")
+			runtime.KeepAlive(c)
+		}
+	}
+
+	b.Run("without trim", func(b *testing.B) {
+		retained(b, false)
+	})
+
+	b.Run("with trim", func(b *testing.B) {
+		retained(b, true)
+	})
+}
+
+func TestForEachSecondaryKey(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+	assert.Nil(t, c.Set("pk3", "value", "a3"))
+
+	// visit every entry
+	seen := make(map[string]string)
+	err = c.ForEachSecondaryKey("a", func(sk string, pk string) bool {
+		seen[sk] = pk
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a1": "pk1", "a2": "pk2", "a3": "pk3"}, seen)
+
+	// stop early
+	n := 0
+	err = c.ForEachSecondaryKey("a", func(sk string, pk string) bool {
+		n++
+		return n < 2
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// an unknown secondary key name
+	err = c.ForEachSecondaryKey("b", func(sk string, pk string) bool {
+		t.Fail()
+		return true
+	})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
+
+func TestValues(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// an empty cache has no values
+	assert.Empty(t, c.Values())
+
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+
+	// every value is returned exactly once
+	values := c.Values()
+	assert.Len(t, values, c.Len())
+	assert.ElementsMatch(t, []string{"value1", "value2", "value3"}, values)
+
+	// the slice is freshly allocated
+	values[0] = "changed"
+	assert.ElementsMatch(t, []string{"value1", "value2", "value3"}, c.Values())
+}
+
+func TestSortedKeys(t *testing.T) {
+	// integer primary keys
+	ci, err := NewMultiKeyCache[int, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	for _, pk := range []int{42, 7, -3, 19, 0} {
+		assert.Nil(t, ci.Set(pk, "value", fmt.Sprint("a", pk)))
+	}
+	assert.Equal(t, []int{-3, 0, 7, 19, 42}, SortedKeys(ci))
+
+	// string primary keys
+	cs, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	for _, pk := range []string{"pear", "apple", "fig", "banana"} {
+		assert.Nil(t, cs.Set(pk, "value", "a-"+pk))
+	}
+	assert.Equal(t, []string{"apple", "banana", "fig", "pear"}, SortedKeys(cs))
+
+	// an empty cache
+	cs.Clear()
+	assert.Empty(t, SortedKeys(cs))
+}
+
+func TestSortedSecondaryKeys(t *testing.T) {
+	// string secondary keys
+	cs, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	for _, sk := range []string{"pear", "apple", "fig", "banana"} {
+		assert.Nil(t, cs.Set("pk-"+sk, "value", sk))
+	}
+	keys, err := SortedSecondaryKeys(cs, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"apple", "banana", "fig", "pear"}, keys)
+
+	// integer secondary keys
+	ci, err := NewMultiKeyCache[string, string, string, int]([]string{"a"})
+	assert.Nil(t, err)
+	for _, sk := range []int{42, 7, -3, 19, 0} {
+		assert.Nil(t, ci.Set(fmt.Sprint("pk", sk), "value", sk))
+	}
+	ints, err := SortedSecondaryKeys(ci, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{-3, 0, 7, 19, 42}, ints)
+
+	// an empty index
+	ci.Clear()
+	ints, err = SortedSecondaryKeys(ci, "a")
+	assert.Nil(t, err)
+	assert.Empty(t, ints)
+
+	// an unknown secondary key name
+	_, err = SortedSecondaryKeys(cs, "b")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
+
+func TestGroupBySecondaryKeyName(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"}, WithPartialSecondaryKeys[string, string, string, string](), WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	assert.Nil(t, c.SetWithTTL("pk4", "value4", time.Second, "a4", "b4"))
+	clock.Advance(time.Second)
+
+	// every live item is grouped alone under its unique secondary key
+	groups, err := c.GroupBySecondaryKeyName("a")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"a1": {"value1"}, "a2": {"value2"}, "a3": {"value3"}}, groups)
+
+	// items without a key under the name are left out
+	groups, err = c.GroupBySecondaryKeyName("b")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"b1": {"value1"}, "b2": {"value2"}}, groups)
+
+	// an unknown secondary key name
+	_, err = c.GroupBySecondaryKeyName("c")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+}
+
+func TestFindBySecondaryKey(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"user", "group", "service"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "u1", "g1", "id42"))
+	assert.Nil(t, c.Set("pk2", "value2", "id42", "g2", "s2"))
+
+	// a match in the second name
+	value, skn, ok, err := c.FindBySecondaryKey("id42", "group", "service", "user")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "service", skn)
+	assert.Equal(t, "value1", value)
+
+	// the order of the names decides which match wins
+	value, skn, ok, err = c.FindBySecondaryKey("id42", "user", "service")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "user", skn)
+	assert.Equal(t, "value2", value)
+
+	// no match across all names
+	value, skn, ok, err = c.FindBySecondaryKey("id43", "user", "group", "service")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", skn)
+	assert.Equal(t, "", value)
+
+	// an unknown secondary key name
+	_, _, ok, err = c.FindBySecondaryKey("id42", "user", "tenant")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "tenant"})
+	assert.False(t, ok)
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", 1, "a1"))
+
+	// a mismatched value leaves the item alone
+	assert.False(t, c.CompareAndDelete("pk1", 2))
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	// a matching value deletes the item and its secondary keys
+	assert.True(t, c.CompareAndDelete("pk1", 1))
+	_, ok = c.Get("pk1")
+	assert.False(t, ok)
+	assert.Empty(t, c.SecondaryKeyNameToKeys("a"))
+
+	// an absent key
+	assert.False(t, c.CompareAndDelete("pk1", 1))
+
+	// the values are compared with the equality of the cache
+	sameName := func(a, b []string) bool { return a[0] == b[0] }
+	d, err := NewMultiKeyCache[string, []string, string, string]([]string{"a"}, WithEquality[string, []string, string, string](sameName))
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", []string{"John", "old"}, "a1"))
+	assert.False(t, d.CompareAndDelete("pk1", []string{"Jane"}))
+	assert.True(t, d.CompareAndDelete("pk1", []string{"John", "new"}))
+	assert.Equal(t, 0, d.Len())
+}
+
+func TestMutate(t *testing.T) {
+	c, err := NewMultiKeyCache[string, []string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", []string{}, "a1"))
+
+	// concurrent mutations of the same item are never lost
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := c.Mutate("pk1", func(old []string) []string {
+				return append(old, fmt.Sprint(i))
+			})
+			assert.True(t, ok)
+		}()
+	}
+	wg.Wait()
+	value, _ := c.Get("pk1")
+	assert.Len(t, value, 100)
+
+	// the new value is returned and the secondary keys are kept
+	value, ok := c.Mutate("pk1", func(old []string) []string { return old[:1] })
+	assert.True(t, ok)
+	assert.Len(t, value, 1)
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Len(t, value, 1)
+
+	// a missing item is not mutated
+	called := false
+	_, ok = c.Mutate("pk2", func(old []string) []string {
+		called = true
+		return old
+	})
+	assert.False(t, ok)
+	assert.False(t, called)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+	}
+	sameName := func(a, b user) bool { return a.Name == b.Name }
+
+	c, err := NewMultiKeyCache[string, user, string, string]([]string{"a"}, WithEquality[string, user, string, string](sameName))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", user{Name: "John"}, "a1"))
+
+	// a successful swap
+	ok := c.CompareAndSwap("pk1", user{Name: "John"}, user{Name: "Jane", Tags: []string{"new"}})
+	assert.True(t, ok)
+	value, _ := c.Get("pk1")
+	assert.Equal(t, user{Name: "Jane", Tags: []string{"new"}}, value)
+
+	// the secondary keys are untouched
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Jane", value.Name)
+
+	// a rejected swap
+	assert.False(t, c.CompareAndSwap("pk1", user{Name: "John"}, user{Name: "Jim"}))
+	value, _ = c.Get("pk1")
+	assert.Equal(t, "Jane", value.Name)
+
+	// the custom equality only looks at the name
+	assert.True(t, c.CompareAndSwap("pk1", user{Name: "Jane"}, user{Name: "Jim"}))
+
+	// an absent key
+	assert.False(t, c.CompareAndSwap("pk2", user{}, user{Name: "Jim"}))
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestSetWithVersion(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// a new item is expected at version 0
+	version, err := c.SetWithVersion("pk1", "value1", 0, "a1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), version)
+
+	// a matching version stores the value and increments it
+	value, version, ok := c.GetWithVersion("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	version, err = c.SetWithVersion("pk1", "value2", version, "a1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+
+	// a stale version is rejected and leaves the item alone
+	version, err = c.SetWithVersion("pk1", "value3", 1, "a1")
+	assert.Equal(t, ErrVersionMismatch[string]{PK: "pk1", Expected: 1, Actual: 2}, err)
+	assert.Equal(t, uint64(2), version)
+	value, _ = c.Get("pk1")
+	assert.Equal(t, "value2", value)
+	_, err = c.SetWithVersion("pk2", "value", 1, "a2")
+	assert.Equal(t, ErrVersionMismatch[string]{PK: "pk2", Expected: 1, Actual: 0}, err)
+
+	// other changes to the value also increment the version
+	assert.Nil(t, c.Set("pk1", "value4", "a1"))
+	assert.True(t, c.CompareAndSwap("pk1", "value4", "value5"))
+	assert.Equal(t, 1, c.UpdateMany(map[string]string{"pk1": "value6"}))
+	_, version, _ = c.GetWithVersion("pk1")
+	assert.Equal(t, uint64(5), version)
+
+	// a missing item has no version
+	_, version, ok = c.GetWithVersion("pk9")
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), version)
+
+	// the set is still validated
+	_, err = c.SetWithVersion("pk2", "value", 0, "a1")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+
+	// the stored version is returned even if the item is evicted right away
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxCost[string, string, string, string](3),
+		WithCostFunc[string, string, string, string](func(v string) int64 { return int64(len(v)) }),
+	)
+	assert.Nil(t, err)
+	version, err = d.SetWithVersion("pk1", "big", 0, "a1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), version)
+	version, err = d.SetWithVersion("pk1", "bigger", 1, "a1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+	assert.Equal(t, 0, d.Len())
+}
+
+func TestDefaultEquality(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+	}
+
+	c, err := NewMultiKeyCache[string, user, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", user{Name: "John", Tags: []string{"admin"}}, "a1"))
+
+	// the default equality compares the whole value deeply
+	assert.False(t, c.CompareAndSwap("pk1", user{Name: "John"}, user{Name: "Jane"}))
+	assert.True(t, c.CompareAndSwap("pk1", user{Name: "John", Tags: []string{"admin"}}, user{Name: "Jane"}))
+	value, _ := c.Get("pk1")
+	assert.Equal(t, user{Name: "Jane"}, value)
+}
+
+func TestIndexEntries(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"})
+	assert.Nil(t, err)
+
+	// an empty cache has no entries
+	assert.Empty(t, c.IndexEntries())
+
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1", "c1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2", "c2"))
+
+	// there is one entry per item and secondary key name
+	entries := c.IndexEntries()
+	assert.Len(t, entries, c.Len()*len(c.SecondaryKeyNames()))
+	assert.ElementsMatch(t, []IndexEntry[string, string, string]{
+		{"a", "a1", "pk1"}, {"b", "b1", "pk1"}, {"c", "c1", "pk1"},
+		{"a", "a2", "pk2"}, {"b", "b2", "pk2"}, {"c", "c2", "pk2"},
+	}, entries)
+
+	// the entries are grouped by name
+	assert.Equal(t, "a", entries[0].SecondaryKeyName)
+	assert.Equal(t, "a", entries[1].SecondaryKeyName)
+	assert.Equal(t, "c", entries[5].SecondaryKeyName)
+}
+
+func TestAddSecondaryKeyName(t *testing.T) {
+	c, err := NewMultiKeyCache[int, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set(1, "john", "a1"))
+	assert.Nil(t, c.Set(2, "jane", "a2"))
+	names := c.SecondaryKeyNames()
+
+	// add a secondary key name built from the existing values
+	err = c.AddSecondaryKeyName("name", func(pk int, v string) string { return v })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "name"}, c.SecondaryKeyNames())
+	assert.Equal(t, []string{"a"}, names)
+
+	// the existing items are indexed under the new name
+	value, ok, err := c.GetBySecondaryKey("name", "jane")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "jane", value)
+	assert.Nil(t, c.VerifyItem(2))
+
+	// set now expects one more secondary key
+	err = c.Set(3, "jim", "a3")
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{Expected: 2, Actual: 1})
+	assert.Nil(t, c.Set(3, "jim", "a3", "jim"))
+
+	// an existing secondary key name
+	err = c.AddSecondaryKeyName("a", func(pk int, v string) string { return v })
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNameNotUnique[string]{SecondaryKeyName: "a"})
+
+	// colliding secondary keys leave the cache unchanged
+	err = c.AddSecondaryKeyName("initial", func(pk int, v string) string { return v[:1] })
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[int, string]{SecondaryKey: "initial"})
+	assert.Equal(t, []string{"a", "name"}, c.SecondaryKeyNames())
+	_, _, err = c.GetBySecondaryKey("initial", "j")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "initial"})
+	assert.Equal(t, map[string]string{"a": "a1", "name": "john"}, c.values[1].secondaryKeys)
+}
+
+func TestRemoveSecondaryKeyName(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1", "c1"))
+	names := c.SecondaryKeyNames()
+
+	// remove the middle secondary key name
+	assert.Nil(t, c.RemoveSecondaryKeyName("b"))
+	assert.Equal(t, []string{"a", "c"}, c.SecondaryKeyNames())
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+	assert.Equal(t, map[string]string{"a": "a1", "c": "c1"}, c.values["pk1"].secondaryKeys)
+
+	// lookups by the removed name fail
+	_, ok, err := c.GetBySecondaryKey("b", "b1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	assert.False(t, ok)
+
+	// the remaining names still work
+	value, ok, err := c.GetBySecondaryKey("c", "c1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	// set now expects one fewer secondary key
+	err = c.Set("pk2", "value", "a2", "b2", "c2")
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{Expected: 2, Actual: 3})
+	assert.Nil(t, c.Set("pk2", "value", "a2", "c2"))
+	value, ok, err = c.GetBySecondaryKey("c", "c2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	// an unknown secondary key name
+	err = c.RemoveSecondaryKeyName("b")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
+
+func TestGetAllWithKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+
+	// every item is returned with its secondary keys
+	views := c.GetAllWithKeys()
+	assert.Equal(t, map[string]ItemView[string, string, string]{
+		"pk1": {Value: "value1", SecondaryKeys: map[string]string{"a": "a1", "b": "b1"}},
+		"pk2": {Value: "value2", SecondaryKeys: map[string]string{"a": "a2", "b": "b2"}},
+	}, views)
+
+	// changing the returned secondary keys does not affect the cache
+	views["pk1"].SecondaryKeys["a"] = "changed"
+	delete(views["pk2"].SecondaryKeys, "b")
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, c.values["pk1"].secondaryKeys)
+	assert.Equal(t, map[string]string{"a": "a2", "b": "b2"}, c.values["pk2"].secondaryKeys)
+	assert.Nil(t, c.VerifyItem("pk1"))
+}
+
+func TestSetWithNamedKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// a complete map
+	assert.Nil(t, c.SetWithNamedKeys("pk1", "value", map[string]string{"b": "b1", "a": "a1"}))
+	value, ok, err := c.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, c.values["pk1"].secondaryKeys)
+
+	// a map missing a name
+	err = c.SetWithNamedKeys("pk2", "value", map[string]string{"a": "a2"})
+	assert.ErrorAs(t, err, &ErrMissingSecondaryKey[string]{SecondaryKeyName: "b"})
+
+	// a complete map with an unknown extra name
+	err = c.SetWithNamedKeys("pk2", "value", map[string]string{"a": "a2", "b": "b2", "c": "c2"})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+
+	// a conflicting secondary key
+	err = c.SetWithNamedKeys("pk2", "value", map[string]string{"a": "a1", "b": "b2"})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{SecondaryKey: "a"})
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestDeleteBySecondaryKeyFunc(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"session", "user"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "tenant42:s1", "u1"))
+	assert.Nil(t, c.Set("pk2", "value", "tenant42:s2", "u2"))
+	assert.Nil(t, c.Set("pk3", "value", "tenant7:s3", "u3"))
+
+	hasPrefix := func(prefix string) func(string) bool {
+		return func(sk string) bool { return strings.HasPrefix(sk, prefix) }
+	}
+
+	// delete by prefix
+	n, err := c.DeleteBySecondaryKeyFunc("session", hasPrefix("tenant42:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"pk3"}, c.Keys())
+
+	// the other indexes were cleaned up as well
+	assert.Equal(t, map[string]string{"u3": "pk3"}, c.SecondaryKeyNameToKeys("user"))
+
+	// a prefix matching nothing
+	n, err = c.DeleteBySecondaryKeyFunc("session", hasPrefix("tenant1:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// an unknown secondary key name
+	n, err = c.DeleteBySecondaryKeyFunc("tenant", hasPrefix(""))
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "tenant"})
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestGetAndDelete(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+
+	// the value is returned exactly once
+	value, ok := c.GetAndDelete("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	value, ok = c.GetAndDelete("pk1")
+	assert.False(t, ok)
+	assert.Equal(t, "", value)
+
+	// the same goes for secondary keys
+	value, ok, err = c.GetAndDeleteBySecondaryKey("b", "b2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+	value, ok, err = c.GetAndDeleteBySecondaryKey("b", "b2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", value)
+
+	// the indexes are cleaned up
+	assert.Equal(t, 0, c.Len())
+	assert.Empty(t, c.IndexEntries())
+
+	// an unknown secondary key name
+	_, ok, err = c.GetAndDeleteBySecondaryKey("c", "c1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+	assert.False(t, ok)
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// the item is inserted
+	ok, err := c.SetIfAbsent("pk1", "value1", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the item is already present
+	ok, err = c.SetIfAbsent("pk1", "changed", "a9")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	value, _ := c.Get("pk1")
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, []string{"a1"}, c.SecondaryKeys("a"))
+
+	// a conflicting secondary key
+	ok, err = c.SetIfAbsent("pk2", "value2", "a1")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk1", NewPK: "pk2"})
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestLoadOrStore(t *testing.T) {
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// the first call stores the value
+	actual, loaded, err := c.LoadOrStore("pk1", 1, "a1")
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, 1, actual)
+
+	// later calls load it, leaving the value and secondary keys alone
+	actual, loaded, err = c.LoadOrStore("pk1", 2, "a2")
+	assert.Nil(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, actual)
+	assert.Equal(t, []string{"a1"}, c.SecondaryKeys("a"))
+
+	// concurrent callers all agree on a single stored value
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := c.LoadOrStore("pk2", i, "a2")
+			assert.Nil(t, err)
+			results[i] = v
+		}()
+	}
+	wg.Wait()
+	value, ok := c.Get("pk2")
+	assert.True(t, ok)
+	for _, v := range results {
+		assert.Equal(t, value, v)
+	}
+
+	// storing is validated like Set
+	_, loaded, err = c.LoadOrStore("pk3", 3, "a1")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk1", NewPK: "pk3"}, err)
+	assert.False(t, loaded)
+	_, _, err = c.LoadOrStore("pk3", 3)
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{})
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestReserve(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	// a reserved item is not found by the lookups
+	assert.Nil(t, c.Reserve("pk1", "a1", "b1"))
+	_, ok := c.Get("pk1")
+	assert.False(t, ok)
+	_, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = c.HasAnySecondaryKey("b", []string{"b1"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// nor is it counted as a set or reported to the subscribers
+	assert.Equal(t, uint64(0), c.Stats().Sets)
+	assert.Len(t, events, 0)
+
+	// a later set fills in the value
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Equal(t, Event[string, string]{Type: EventSet, PK: "pk1", Value: "value1"}, <-events)
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	value, ok, err = c.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// a pk holding a value cannot be reserved
+	err = c.Reserve("pk1", "a1", "b1")
+	assert.Equal(t, ErrPrimaryKeyExists[string]{PK: "pk1"}, err)
+	assert.Empty(t, c.Verify())
+}
+
+func TestReserveConflict(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Reserve("pk1", "a1", "b1"))
+
+	// the reserved secondary keys are taken for other pks, by sets and reservations alike
+	err = c.Set("pk2", "value2", "a2", "b1")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "b", ExistingPK: "pk1", NewPK: "pk2"}, err)
+	err = c.Reserve("pk3", "a1", "b3")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk1", NewPK: "pk3"}, err)
+
+	// a reservation may be renewed with other secondary keys, which frees the old ones
+	assert.Nil(t, c.Reserve("pk1", "a9", "b9"))
+	assert.Nil(t, c.Set("pk2", "value2", "a1", "b1"))
+	value, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+	assert.Empty(t, c.Verify())
+}
+
+func TestReserveEvicted(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxCost[string, string, string, string](5),
+		WithCostFunc[string, string, string, string](func(v string) int64 { return 10 }),
+	)
+	assert.Nil(t, err)
+
+	// a reservation evicted right away leaves nothing behind
+	assert.Nil(t, c.Reserve("pk1", "a1"))
+	assert.Empty(t, c.values)
+	assert.Empty(t, c.Verify())
+
+	// and the pk can still be set
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Empty(t, c.Verify())
+}
+
+func TestGetEntryBySecondaryKey(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.SetWithTTL("pk2", "value2", time.Second, "a2"))
+	clock.Advance(time.Second)
+
+	// a hit returns both the pk and the value
+	pk, value, found, err := c.GetEntryBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "pk1", pk)
+	assert.Equal(t, "value1", value)
+
+	// a missing or expired item returns zero values
+	for _, sk := range []string{"a9", "a2"} {
+		pk, value, found, err = c.GetEntryBySecondaryKey("a", sk)
+		assert.Nil(t, err)
+		assert.False(t, found)
+		assert.Empty(t, pk)
+		assert.Empty(t, value)
+	}
+
+	// an unknown secondary key name
+	pk, _, found, err = c.GetEntryBySecondaryKey("b", "b1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	assert.False(t, found)
+	assert.Empty(t, pk)
+}
+
+func TestGetManyBySecondaryKey(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+
+	// a mix of hits and misses
+	values, err := c.GetManyBySecondaryKey("b", []string{"b1", "b2", "b3"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"b1": "value1", "b2": "value2"}, values)
+	assert.Equal(t, uint64(2), c.Stats().Hits)
+	assert.Equal(t, uint64(1), c.Stats().Misses)
+
+	// only misses
+	values, err = c.GetManyBySecondaryKey("a", []string{"a3"})
+	assert.Nil(t, err)
+	assert.Empty(t, values)
+
+	// an unknown secondary key name
+	values, err = c.GetManyBySecondaryKey("c", []string{"c1"})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+	assert.Nil(t, values)
+}
+
+func TestKeysBySecondaryKeyFunc(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"session", "user"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "tenant42:s1", "u1"))
+	assert.Nil(t, c.Set("pk2", "value", "tenant42:s2", "u2"))
+	assert.Nil(t, c.Set("pk3", "value", "tenant7:s3", "u3"))
+
+	hasPrefix := func(prefix string) func(string) bool {
+		return func(sk string) bool { return strings.HasPrefix(sk, prefix) }
+	}
+
+	// a prefix matching some keys
+	keys, err := c.KeysBySecondaryKeyFunc("session", hasPrefix("tenant42:"))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"pk1", "pk2"}, keys)
+
+	// a prefix matching nothing
+	keys, err = c.KeysBySecondaryKeyFunc("session", hasPrefix("tenant1:"))
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	// nothing was deleted
+	assert.Equal(t, 3, c.Len())
+
+	// an unknown secondary key name
+	keys, err = c.KeysBySecondaryKeyFunc("tenant", hasPrefix(""))
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "tenant"})
+	assert.Nil(t, keys)
+}
+
+func TestContainsSecondaryKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+
+	// all present
+	ok, err := c.ContainsAllSecondaryKeys("a", []string{"a1", "a2"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = c.HasAnySecondaryKey("a", []string{"a1", "a2"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// some present
+	ok, err = c.ContainsAllSecondaryKeys("a", []string{"a1", "a3"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = c.HasAnySecondaryKey("a", []string{"a3", "a1"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// none present
+	ok, err = c.ContainsAllSecondaryKeys("a", []string{"a3", "a4"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = c.HasAnySecondaryKey("a", []string{"a3", "a4"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// an unknown secondary key name
+	_, err = c.ContainsAllSecondaryKeys("b", []string{"b1"})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	_, err = c.HasAnySecondaryKey("b", []string{"b1"})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
+
+func TestSecondaryKeyOf(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+
+	// a present item
+	sk, ok, err := c.SecondaryKeyOf("pk1", "b")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b1", sk)
+
+	// an absent item
+	sk, ok, err = c.SecondaryKeyOf("pk2", "b")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", sk)
+
+	// an item without a key under the name
+	assert.Nil(t, c.ClearIndex("a"))
+	_, ok, err = c.SecondaryKeyOf("pk1", "a")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// an unknown secondary key name
+	_, ok, err = c.SecondaryKeyOf("pk1", "c")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+	assert.False(t, ok)
+}
+
+func TestSecondaryKeysOf(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+
+	// a present item
+	keys, ok := c.SecondaryKeysOf("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, keys)
+
+	// the map is a copy
+	keys["a"] = "a9"
+	delete(keys, "b")
+	keys, _ = c.SecondaryKeysOf("pk1")
+	assert.Equal(t, map[string]string{"a": "a1", "b": "b1"}, keys)
+	assert.Nil(t, c.VerifyItem("pk1"))
+
+	// an absent item
+	keys, ok = c.SecondaryKeysOf("pk2")
+	assert.False(t, ok)
+	assert.Nil(t, keys)
+}
+
+func TestRemapSecondaryKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3", "b3"))
+
+	// a clean remap, ignoring keys that are not in the cache
+	n, err := c.RemapSecondaryKeys("a", map[string]string{"a1": "x1", "a2": "x2", "a9": "x9"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]string{"x1": "pk1", "x2": "pk2", "a3": "pk3"}, c.SecondaryKeyNameToKeys("a"))
+	keys, _ := c.SecondaryKeysOf("pk1")
+	assert.Equal(t, map[string]string{"a": "x1", "b": "b1"}, keys)
+	assert.Empty(t, c.Verify())
+
+	// keys may be swapped
+	n, err = c.RemapSecondaryKeys("b", map[string]string{"b1": "b2", "b2": "b1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	value, _, _ := c.GetBySecondaryKey("b", "b1")
+	assert.Equal(t, "value2", value)
+	assert.Empty(t, c.Verify())
+
+	// a new key colliding with an unrelated pk changes nothing
+	n, err = c.RemapSecondaryKeys("a", map[string]string{"x1": "y1", "x2": "a3"})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk3", NewPK: "pk2"}, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, map[string]string{"x1": "pk1", "x2": "pk2", "a3": "pk3"}, c.SecondaryKeyNameToKeys("a"))
+
+	// so do two old keys mapped to the same new key
+	_, err = c.RemapSecondaryKeys("a", map[string]string{"x1": "y", "x2": "y"})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+	assert.Equal(t, map[string]string{"x1": "pk1", "x2": "pk2", "a3": "pk3"}, c.SecondaryKeyNameToKeys("a"))
+
+	// an unknown secondary key name
+	_, err = c.RemapSecondaryKeys("c", map[string]string{"c1": "c2"})
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "c"})
+}
+
+func TestCompositeSecondaryKeys(t *testing.T) {
+	type tenantKey struct {
+		Region string
+		Tenant int
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, tenantKey]([]string{"tenant"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", tenantKey{Region: "eu", Tenant: 1}))
+	assert.Nil(t, c.Set("pk2", "value2", tenantKey{Region: "us", Tenant: 1}))
+	assert.Nil(t, c.Set("pk3", "value3", tenantKey{Region: "eu", Tenant: 2}))
+
+	// struct keys are compared by all their fields
+	value, ok, err := c.GetBySecondaryKey("tenant", tenantKey{Region: "us", Tenant: 1})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+	_, ok, err = c.GetBySecondaryKey("tenant", tenantKey{Region: "us", Tenant: 2})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// an equal struct is a conflicting key
+	err = c.Set("pk4", "value4", tenantKey{Region: "eu", Tenant: 2})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+
+	// the keys are listed like any other
+	assert.ElementsMatch(t, []tenantKey{{Region: "eu", Tenant: 1}, {Region: "eu", Tenant: 2}, {Region: "us", Tenant: 1}}, c.SecondaryKeys("tenant"))
+
+	// deleting by a struct key works like any other
+	assert.Nil(t, c.DeleteBySecondaryKey("tenant", tenantKey{Region: "eu", Tenant: 1}))
+	assert.Equal(t, 2, c.Len())
+	assert.Empty(t, c.Verify())
+}
+
+func TestIncrement(t *testing.T) {
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", 1, "a1"))
+
+	// an existing counter
+	value, ok := Increment(c, "pk1", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+	value, ok = Increment(c, "pk1", -5)
+	assert.True(t, ok)
+	assert.Equal(t, -2, value)
+	value, _ = c.Get("pk1")
+	assert.Equal(t, -2, value)
+
+	// the secondary keys are untouched
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, -2, value)
+
+	// an absent counter is not created
+	value, ok = Increment(c, "pk2", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, value)
+	assert.Equal(t, 1, c.Len())
+
+	// concurrent increments are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Increment(c, "pk1", 1)
+		}()
+	}
+	wg.Wait()
+	value, _ = c.Get("pk1")
+	assert.Equal(t, 98, value)
+}
+
+func TestGetBySecondaryKeyOrDefault(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+
+	// a present item
+	value, err := c.GetBySecondaryKeyOrDefault("a", "a1", "default")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", value)
+
+	// an absent item
+	value, err = c.GetBySecondaryKeyOrDefault("a", "a2", "default")
+	assert.Nil(t, err)
+	assert.Equal(t, "default", value)
+
+	// an unknown secondary key name
+	value, err = c.GetBySecondaryKeyOrDefault("b", "b1", "default")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	assert.Equal(t, "", value)
+}
+
+func TestRekey(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("tmp1", "value1", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2"))
+
+	// a successful rekey moves the item and its secondary keys
+	assert.Nil(t, c.Rekey("tmp1", "pk1"))
+	_, ok := c.Get("tmp1")
+	assert.False(t, ok)
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, map[string]string{"a1": "pk1", "a2": "pk2"}, c.SecondaryKeyNameToKeys("a"))
+	assert.Equal(t, map[string]string{"b1": "pk1", "b2": "pk2"}, c.SecondaryKeyNameToKeys("b"))
+	assert.Empty(t, c.Verify())
+
+	// a missing source
+	err = c.Rekey("tmp1", "pk3")
+	assert.ErrorAs(t, err, &ErrPrimaryKeyNotFound[string]{})
+	assert.EqualError(t, err, "pk tmp1 not found")
+
+	// an occupied destination
+	err = c.Rekey("pk1", "pk2")
+	assert.Equal(t, ErrPrimaryKeyExists[string]{PK: "pk2"}, err)
+	value, _ = c.Get("pk2")
+	assert.Equal(t, "value2", value)
+	assert.Equal(t, 2, c.Len())
+
+	// rekeying onto itself changes nothing
+	assert.Nil(t, c.Rekey("pk1", "pk1"))
+	assert.Equal(t, 2, c.Len())
+}
@@ -0,0 +1,105 @@
+package multikeycache
+
+// EventType describes the kind of change an Event reports
+type EventType int
+
+const (
+	// EventSet means an item was stored or its value was replaced
+	EventSet EventType = iota
+	// EventDelete means an item was deleted by the caller
+	EventDelete
+	// EventEvict means an item was removed by the cache itself
+	EventEvict
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "Set"
+	case EventDelete:
+		return "Delete"
+	case EventEvict:
+		return "Evict"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event describes a single change to the cache
+type Event[PKT comparable, VT any] struct {
+	Type  EventType
+	PK    PKT
+	Value VT
+	// Reason is the reason for the eviction and is only meaningful for EventEvict
+	Reason EvictReason
+}
+
+// defaultEventBuffer is the size of the subscriber channels when WithEventBuffer is not used
+const defaultEventBuffer = 64
+
+// Subscribe returns a channel receiving an Event for every change to the cache,
+// and a function that unsubscribes and closes the channel.
+// Events are delivered after the lock is released. When the channel is full the event
+// is dropped rather than blocking the cache, and counted in Stats.DroppedEvents
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Subscribe() (<-chan Event[PKT, VT], func()) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	size := c.eventBuffer
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+
+	if c.subscribers == nil {
+		c.subscribers = make(map[int]chan Event[PKT, VT])
+	}
+
+	id := c.nextSubscriber
+	c.nextSubscriber++
+	ch := make(chan Event[PKT, VT], size)
+	c.subscribers[id] = ch
+	c.subscribed.Add(1)
+
+	unsubscribe := func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+
+		if _, ok := c.subscribers[id]; !ok {
+			return
+		}
+		delete(c.subscribers, id)
+		c.subscribed.Add(-1)
+		close(ch)
+	}
+
+	return ch, unsubscribe
+}
+
+// emit queues an event with a copy of the value for the subscribers, if there are any.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) emit(t EventType, it item[PKT, VT, SKNT, SKT], reason EvictReason) {
+	if c.subscribed.Load() == 0 {
+		return
+	}
+
+	c.events = append(c.events, Event[PKT, VT]{Type: t, PK: it.pk, Value: c.cloneValue(it.value), Reason: reason})
+}
+
+// deliver sends the events to every subscriber without blocking, each with its own copy
+// of the value, dropping the events that do not fit in a subscriber's channel
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) deliver(events []Event[PKT, VT]) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for _, ch := range c.subscribers {
+		for _, ev := range events {
+			ev.Value = c.cloneValue(ev.Value)
+			select {
+			case ch <- ev:
+			default:
+				c.stats.droppedEvents.Add(1)
+			}
+		}
+	}
+}
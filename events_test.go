@@ -0,0 +1,63 @@
+package multikeycache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithMaxEntries[string, string, string, string](1))
+	assert.Nil(t, err)
+
+	events, unsubscribe := c.Subscribe()
+
+	// sets, deletes and evictions are all delivered in order
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	c.Delete("pk2")
+	assert.Equal(t, Event[string, string]{Type: EventSet, PK: "pk1", Value: "value1"}, <-events)
+	assert.Equal(t, Event[string, string]{Type: EventSet, PK: "pk2", Value: "value2"}, <-events)
+	assert.Equal(t, Event[string, string]{Type: EventEvict, PK: "pk1", Value: "value1", Reason: EvictReasonCapacity}, <-events)
+	assert.Equal(t, Event[string, string]{Type: EventDelete, PK: "pk2", Value: "value2"}, <-events)
+
+	// deleting a missing item delivers nothing
+	c.Delete("pk2")
+	assert.Len(t, events, 0)
+
+	// clearing the cache reports the items as evicted
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	c.Clear()
+	assert.Equal(t, EventSet, (<-events).Type)
+	assert.Equal(t, Event[string, string]{Type: EventEvict, PK: "pk3", Value: "value3", Reason: EvictReasonCleared}, <-events)
+
+	// unsubscribing closes the channel and stops delivery
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok)
+	assert.Nil(t, c.Set("pk4", "value4", "a4"))
+	assert.Len(t, c.subscribers, 0)
+
+	// unsubscribing twice is harmless
+	unsubscribe()
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithEventBuffer[string, string, string, string](2))
+	assert.Nil(t, err)
+
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	// a subscriber that does not read never blocks the cache
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	c.Delete("pk1")
+
+	// the events beyond the buffer are dropped and counted
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(2), c.Stats().DroppedEvents)
+	assert.Equal(t, "pk1", (<-events).PK)
+	assert.Equal(t, "pk2", (<-events).PK)
+}
@@ -0,0 +1,204 @@
+package multikeycache
+
+// EvictReason describes why an item was removed from the cache by the cache itself
+type EvictReason int
+
+const (
+	// EvictReasonCleared means the item was removed by clearing the cache
+	EvictReasonCleared EvictReason = iota
+	// EvictReasonCapacity means the item was the least recently used one
+	// when the cache grew beyond its maximum number of entries or its maximum cost,
+	// or when EvictOldest was called
+	EvictReasonCapacity
+	// EvictReasonExpired means the item was removed after its time to live had passed
+	EvictReasonExpired
+	// EvictReasonReplaced means the item held a secondary key taken by another item
+	// under the OverwriteExisting conflict policy
+	EvictReasonReplaced
+)
+
+// String returns the name of the evict reason
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonCleared:
+		return "Cleared"
+	case EvictReasonCapacity:
+		return "Capacity"
+	case EvictReasonExpired:
+		return "Expired"
+	case EvictReasonReplaced:
+		return "Replaced"
+	default:
+		return "Unknown"
+	}
+}
+
+// eviction is an item removed by the cache itself, together with the reason for the removal
+type eviction[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	item   item[PKT, VT, SKNT, SKT]
+	reason EvictReason
+}
+
+// evict removes the item from the cache and queues it for the OnEvict hook and the subscribers.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) evict(it item[PKT, VT, SKNT, SKT], reason EvictReason) {
+	c.remove(it)
+	c.stats.evictions.Add(1)
+	c.queueEviction(it, reason)
+}
+
+// queueEviction queues an evicted item for the OnEvict hook and the subscribers.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) queueEviction(it item[PKT, VT, SKNT, SKT], reason EvictReason) {
+	if c.onEvict != nil {
+		c.evicted = append(c.evicted, eviction[PKT, VT, SKNT, SKT]{item: it, reason: reason})
+	}
+	if c.observer != nil {
+		c.observedEvictions = append(c.observedEvictions, reason)
+	}
+	c.emit(EventEvict, it, reason)
+}
+
+// unlock releases the write lock and then calls the OnEvict hook for every item
+// evicted while holding it, so the hook may use the cache, reports the changes
+// to the Observer and delivers the queued events
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) unlock() {
+	evicted := c.evicted
+	events := c.events
+	sets, evictions := c.observedSets, c.observedEvictions
+	c.evicted = nil
+	c.events = nil
+	c.observedSets, c.observedEvictions = 0, nil
+	c.mu.Unlock()
+
+	for _, e := range evicted {
+		c.onEvict(e.item.pk, e.item.value, e.reason)
+	}
+
+	if c.observer != nil {
+		for range sets {
+			c.observer.OnSet()
+		}
+		for _, reason := range evictions {
+			c.observer.OnEvict(reason)
+		}
+	}
+
+	if len(events) > 0 {
+		c.deliver(events)
+	}
+}
+
+// PauseEviction stops the cache from evicting items until ResumeEviction is called.
+// While paused, the cache may grow beyond its maximum number of entries,
+// e.g. to build a set of related items without losing any of them halfway through
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) PauseEviction() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictionPaused = true
+}
+
+// ResumeEviction lets the cache evict items again
+// and immediately evicts the least recently used items the cache has grown beyond its limits
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ResumeEviction() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.evictionPaused = false
+	c.evictOverflow()
+}
+
+// SetMaxEntries changes the maximum number of items in the cache, where zero means no limit.
+// If the cache holds more items than the new limit, the least recently used items
+// are evicted right away, unless eviction is paused
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetMaxEntries(n int) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.maxEntries = n
+	c.evictOverflow()
+}
+
+// EvictOldest evicts up to n of the least recently used items with EvictReasonCapacity
+// and returns the number of evicted items, e.g. to shed memory when the heap grows too large.
+// It evicts even while eviction is paused, since it is requested explicitly
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) EvictOldest(n int) int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	evicted := 0
+	for evicted < n && c.lru.Len() > 0 {
+		c.evict(c.values[c.lru.Front().Value.(PKT)], EvictReasonCapacity)
+		evicted++
+	}
+
+	return evicted
+}
+
+// OldestToNewest calls fn for every live item from the least to the most recently used,
+// which is the order in which they would be evicted, until fn returns false.
+// The function is called while holding the read lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) OldestToNewest(fn func(pk PKT, v VT) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+
+	now := c.now()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		item := c.values[e.Value.(PKT)]
+		if !item.live(now) {
+			continue
+		}
+
+		if !fn(item.pk, c.read(item)) {
+			return
+		}
+	}
+}
+
+// touch marks the item as the most recently used.
+// The caller must hold at least the read lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) touch(it item[PKT, VT, SKNT, SKT]) {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+
+	c.lru.MoveToBack(it.elem)
+}
+
+// evictOverflow evicts the least recently used items until the cache is within its limits.
+// Nothing is evicted while eviction is paused.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) evictOverflow() {
+	if c.evictionPaused {
+		return
+	}
+
+	for c.overflowing() {
+		c.evict(c.values[c.lru.Front().Value.(PKT)], EvictReasonCapacity)
+	}
+}
+
+// overflowing returns true if the cache holds more entries or more cost than its limits allow.
+// The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) overflowing() bool {
+	if c.maxEntries > 0 && len(c.values) > c.maxEntries {
+		return true
+	}
+
+	return c.maxCost > 0 && c.totalCost > c.maxCost
+}
+
+// recost updates the cost of the item from its value and adjusts the total cost of the cache
+// by the difference. The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) recost(it *item[PKT, VT, SKNT, SKT]) {
+	if c.costFunc == nil {
+		return
+	}
+
+	cost := c.costFunc(it.value)
+	c.totalCost += cost - it.cost
+	it.cost = cost
+}
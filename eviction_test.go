@@ -0,0 +1,339 @@
+package multikeycache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClearOnEvict(t *testing.T) {
+	var c *multiKeyCache[string, string, string, string]
+	evicted := make(map[string]EvictReason)
+	onEvict := func(pk string, v string, reason EvictReason) {
+		evicted[pk] = reason
+
+		// the hook is called without holding the lock
+		assert.Equal(t, 0, c.Len())
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithOnEvict[string, string, string, string](onEvict))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+	assert.Nil(t, c.Set("pk3", "value", "a3"))
+
+	// clear the cache
+	n := c.Len()
+	c.Clear()
+
+	// the hook was called once for every item
+	assert.Len(t, evicted, n)
+	assert.Equal(t, map[string]EvictReason{"pk1": EvictReasonCleared, "pk2": EvictReasonCleared, "pk3": EvictReasonCleared}, evicted)
+
+	// the cache is still usable after clearing
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	value, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+}
+
+func TestMaxEntries(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonCapacity, reason)
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxEntries[string, string, string, string](2),
+		WithOnEvict[string, string, string, string](onEvict),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+
+	// using pk1 makes pk2 the least recently used item
+	_, ok := c.Get("pk1")
+	assert.True(t, ok)
+
+	// exceeding the limit evicts pk2
+	assert.Nil(t, c.Set("pk3", "value", "a3"))
+	assert.Equal(t, []string{"pk2"}, evicted)
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+
+	// the evicted item is gone from the indexes too
+	_, ok, err = c.GetBySecondaryKey("a", "a2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// lookups by secondary key count as use as well
+	_, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, c.Set("pk4", "value", "a4"))
+	assert.Equal(t, []string{"pk2", "pk3"}, evicted)
+}
+
+func TestPauseEviction(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxEntries[string, string, string, string](2),
+		WithOnEvict[string, string, string, string](onEvict),
+	)
+	assert.Nil(t, err)
+
+	// insert past the limit while eviction is paused
+	c.PauseEviction()
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+	assert.Nil(t, c.Set("pk3", "value", "a3"))
+	assert.Nil(t, c.Set("pk4", "value", "a4"))
+
+	// nothing has been evicted yet
+	assert.Empty(t, evicted)
+	assert.Equal(t, 4, c.Len())
+
+	// resuming evicts the least recently used items down to the limit
+	c.ResumeEviction()
+	assert.Equal(t, []string{"pk1", "pk2"}, evicted)
+	assert.Equal(t, 2, c.Len())
+	assert.ElementsMatch(t, []string{"pk3", "pk4"}, c.Keys())
+}
+
+func TestMaxCost(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonCapacity, reason)
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxCost[string, string, string, string](10),
+		WithCostFunc[string, string, string, string](func(v string) int64 { return int64(len(v)) }),
+		WithOnEvict[string, string, string, string](onEvict),
+	)
+	assert.Nil(t, err)
+
+	// fill the cache right up to the limit
+	assert.Nil(t, c.Set("pk1", "xxxx", "a1"))
+	assert.Nil(t, c.Set("pk2", "xxx", "a2"))
+	assert.Nil(t, c.Set("pk3", "xxx", "a3"))
+	assert.Empty(t, evicted)
+	assert.Equal(t, int64(10), c.totalCost)
+
+	// exceeding the limit evicts just enough of the least recently used items
+	assert.Nil(t, c.Set("pk4", "xx", "a4"))
+	assert.Equal(t, []string{"pk1"}, evicted)
+	assert.Equal(t, int64(8), c.totalCost)
+
+	// a costly item evicts several items
+	assert.Nil(t, c.Set("pk5", "xxxxxxx", "a5"))
+	assert.Equal(t, []string{"pk1", "pk2", "pk3"}, evicted)
+	assert.Equal(t, int64(9), c.totalCost)
+	assert.ElementsMatch(t, []string{"pk4", "pk5"}, c.Keys())
+
+	// overwriting an item replaces its cost
+	assert.Nil(t, c.Set("pk5", "x", "a5"))
+	assert.Equal(t, int64(3), c.totalCost)
+
+	// deleting an item releases its cost
+	c.Delete("pk4")
+	assert.Equal(t, int64(1), c.totalCost)
+
+	// updating values is accounted for as well, without changing the recency order
+	assert.Nil(t, c.Set("pk6", "xxxx", "a6"))
+	c.UpdateMany(map[string]string{"pk5": "xxxxxxxx"})
+	assert.Equal(t, []string{"pk1", "pk2", "pk3", "pk5"}, evicted)
+	assert.Equal(t, int64(4), c.totalCost)
+}
+
+func TestPeek(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxEntries[string, string, string, string](2),
+		WithOnEvict[string, string, string, string](onEvict),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+
+	// peeking at the least recently used item returns it
+	value, ok := c.Peek("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	value, ok, err = c.PeekBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// but does not save it from eviction
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	assert.Equal(t, []string{"pk1"}, evicted)
+
+	// getting the least recently used item does
+	_, ok = c.Get("pk2")
+	assert.True(t, ok)
+	assert.Nil(t, c.Set("pk4", "value4", "a4"))
+	assert.Equal(t, []string{"pk1", "pk3"}, evicted)
+
+	// peeking does not count as a hit or miss
+	c.ResetStats()
+	c.Peek("pk2")
+	c.Peek("pk5")
+	assert.Equal(t, Stats{}, c.Stats())
+
+	// misses and unknown secondary key names
+	_, ok = c.Peek("pk1")
+	assert.False(t, ok)
+	_, ok, err = c.PeekBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = c.PeekBySecondaryKey("b", "b1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	assert.False(t, ok)
+}
+
+func TestSetMaxEntries(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonCapacity, reason)
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithMaxEntries[string, string, string, string](3),
+		WithOnEvict[string, string, string, string](onEvict),
+	)
+	assert.Nil(t, err)
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, c.Set(fmt.Sprint("pk", i), "value", fmt.Sprint("a", i)))
+	}
+
+	// shrinking the limit evicts right away
+	c.SetMaxEntries(1)
+	assert.Equal(t, []string{"pk1", "pk2"}, evicted)
+	assert.Equal(t, []string{"pk3"}, c.Keys())
+
+	// growing the limit makes room for more items
+	c.SetMaxEntries(2)
+	assert.Nil(t, c.Set("pk4", "value", "a4"))
+	assert.Equal(t, 2, c.Len())
+	assert.Nil(t, c.Set("pk5", "value", "a5"))
+	assert.Equal(t, []string{"pk1", "pk2", "pk3"}, evicted)
+
+	// zero removes the limit
+	c.SetMaxEntries(0)
+	for i := 6; i <= 10; i++ {
+		assert.Nil(t, c.Set(fmt.Sprint("pk", i), "value", fmt.Sprint("a", i)))
+	}
+	assert.Equal(t, 7, c.Len())
+	assert.Len(t, evicted, 3)
+}
+
+func TestEvictOldest(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonCapacity, reason)
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithOnEvict[string, string, string, string](onEvict))
+	assert.Nil(t, err)
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, c.Set(fmt.Sprintf("pk%d", i), "value", fmt.Sprintf("a%d", i)))
+	}
+
+	// using pk1 makes pk2 the least recently used item
+	_, ok := c.Get("pk1")
+	assert.True(t, ok)
+
+	// fewer than the number of items
+	assert.Equal(t, 2, c.EvictOldest(2))
+	assert.Equal(t, []string{"pk2", "pk3"}, evicted)
+	assert.ElementsMatch(t, []string{"a1", "a4", "a5"}, c.SecondaryKeys("a"))
+
+	// exactly the number of items
+	evicted = nil
+	assert.Equal(t, 3, c.EvictOldest(3))
+	assert.Equal(t, []string{"pk4", "pk5", "pk1"}, evicted)
+	assert.Equal(t, 0, c.Len())
+	assert.Empty(t, c.SecondaryKeys("a"))
+
+	// more than the number of items
+	assert.Nil(t, c.Set("pk6", "value", "a6"))
+	assert.Equal(t, 1, c.EvictOldest(10))
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, 0, c.EvictOldest(1))
+	assert.Equal(t, uint64(6), c.Stats().Evictions)
+}
+
+func TestDrain(t *testing.T) {
+	evicted := make(map[string]EvictReason)
+	onEvict := func(pk string, v string, reason EvictReason) {
+		evicted[pk] = reason
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithOnEvict[string, string, string, string](onEvict))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+
+	// the drained values match the contents before draining
+	expected := c.GetAll()
+	assert.Equal(t, expected, c.Drain())
+	assert.Equal(t, map[string]EvictReason{"pk1": EvictReasonCleared, "pk2": EvictReasonCleared}, evicted)
+
+	// the cache is empty afterwards
+	assert.Equal(t, 0, c.Len())
+	assert.Empty(t, c.SecondaryKeys("a"))
+	_, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, c.Drain())
+
+	// and still usable
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestOldestToNewest(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+
+	// use pk1, then pk2 by secondary key
+	_, ok := c.Get("pk1")
+	assert.True(t, ok)
+	_, ok, err = c.GetBySecondaryKey("a", "a2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the items are visited from the least to the most recently used
+	var visited []string
+	c.OldestToNewest(func(pk string, v string) bool {
+		visited = append(visited, pk+"="+v)
+		return true
+	})
+	assert.Equal(t, []string{"pk3=value3", "pk1=value1", "pk2=value2"}, visited)
+
+	// the walk stops early
+	visited = nil
+	c.OldestToNewest(func(pk string, v string) bool {
+		visited = append(visited, pk)
+		return len(visited) < 2
+	})
+	assert.Equal(t, []string{"pk3", "pk1"}, visited)
+}
@@ -0,0 +1,112 @@
+package multikeycache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+// gobItem is the wire representation of a single item
+type gobItem[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	PK            PKT
+	Value         VT
+	SecondaryKeys map[SKNT]SKT
+	ExpiresAt     time.Time
+	Reserved      bool
+}
+
+// gobCache is the wire representation of the whole cache
+type gobCache[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	SecondaryKeyNames []SKNT
+	Items             []gobItem[PKT, VT, SKNT, SKT]
+}
+
+// GobEncode encodes the cache using encoding/gob.
+// The secondary indexes are stored as part of each item and rebuilt when decoding,
+// and the items are stored in recency order so decoding preserves it.
+// Items claimed by Reserve stay reserved
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GobEncode() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	gc := gobCache[PKT, VT, SKNT, SKT]{
+		SecondaryKeyNames: c.secondaryKeyNames,
+		Items:             make([]gobItem[PKT, VT, SKNT, SKT], 0, len(c.values)),
+	}
+
+	c.lruMu.Lock()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		item := c.values[e.Value.(PKT)]
+		gc.Items = append(gc.Items, gobItem[PKT, VT, SKNT, SKT]{
+			PK:            item.pk,
+			Value:         item.value,
+			SecondaryKeys: item.secondaryKeys,
+			ExpiresAt:     item.expiresAt,
+			Reserved:      item.reserved,
+		})
+	}
+	c.lruMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gc); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the contents of the cache with the gob encoded data
+// and returns an error if the secondary key names are not unique
+// or if a secondary key is used by more than one primary key
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GobDecode(data []byte) error {
+	var gc gobCache[PKT, VT, SKNT, SKT]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gc); err != nil {
+		return err
+	}
+
+	// build the new state with the same validation as the constructor
+	n, err := NewMultiKeyCache[PKT, VT, SKNT, SKT](gc.SecondaryKeyNames)
+	if err != nil {
+		return err
+	}
+	n.costFunc = c.costFunc
+
+	for _, gi := range gc.Items {
+		item := item[PKT, VT, SKNT, SKT]{
+			pk:            gi.PK,
+			value:         gi.Value,
+			secondaryKeys: make(map[SKNT]SKT, len(gi.SecondaryKeys)),
+			expiresAt:     gi.ExpiresAt,
+			reserved:      gi.Reserved,
+		}
+
+		for _, skn := range n.secondaryKeyNames {
+			sk, ok := gi.SecondaryKeys[skn]
+			if !ok {
+				continue
+			}
+
+			// check if the secondary key already exists for a different pk
+			if spk, ok := n.indexes[skn][sk]; ok && spk != gi.PK {
+				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: gi.PK}
+			}
+
+			item.secondaryKeys[skn] = sk
+		}
+
+		n.store(item)
+	}
+
+	// swap in the decoded state
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.values = n.values
+	c.indexes = n.indexes
+	c.secondaryKeyNames = n.secondaryKeyNames
+	c.lru = n.lru
+	c.totalCost = n.totalCost
+	c.evictOverflow()
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package multikeycache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type gobUserID int
+
+type gobKeyName string
+
+type gobUser struct {
+	Name  string
+	Email string
+	Tags  []string
+}
+
+func TestGob(t *testing.T) {
+	c, err := NewMultiKeyCache[gobUserID, gobUser, gobKeyName, string]([]gobKeyName{"email", "username"})
+	assert.Nil(t, err)
+
+	// populate the cache
+	assert.Nil(t, c.Set(1, gobUser{Name: "John", Email: "john@example.com", Tags: []string{"admin"}}, "john@example.com", "john123"))
+	assert.Nil(t, c.Set(2, gobUser{Name: "Jane", Email: "jane@example.com"}, "jane@example.com", "jane123"))
+
+	// encode the cache
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(c))
+
+	// decode into a fresh cache
+	d, err := NewMultiKeyCache[gobUserID, gobUser, gobKeyName, string](nil)
+	assert.Nil(t, err)
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(d))
+
+	// check that the contents survived the round trip
+	assert.Equal(t, []gobKeyName{"email", "username"}, d.SecondaryKeyNames())
+	assert.Equal(t, c.GetAll(), d.GetAll())
+
+	// check that the secondary indexes were rebuilt
+	value, ok, err := d.GetBySecondaryKey("username", "john123")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"admin"}, value.Tags)
+	assert.Empty(t, c.IndexDiff(d))
+
+	// the decoded cache is fully usable
+	err = d.Set(3, gobUser{Name: "Jim"}, "jane@example.com", "jim123")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[gobUserID, gobKeyName]{})
+}
+
+func TestGobReserved(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Reserve("pk2", "a2"))
+
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(c))
+	d, err := NewMultiKeyCache[string, string, string, string](nil)
+	assert.Nil(t, err)
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(d))
+
+	// the reservation survives the round trip, still missing but holding its keys
+	_, ok := d.Get("pk2")
+	assert.False(t, ok)
+	assert.Equal(t, 1, d.Len())
+	err = d.Set("pk3", "value3", "a2")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk2", NewPK: "pk3"}, err)
+
+	// and is filled in by a later set
+	assert.Nil(t, d.Set("pk2", "value2", "a2"))
+	value, ok := d.Get("pk2")
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+}
+
+func TestGobDecodeValidation(t *testing.T) {
+	encode := func(gc gobCache[int, string, string, string]) []byte {
+		var buf bytes.Buffer
+		assert.Nil(t, gob.NewEncoder(&buf).Encode(gc))
+		return buf.Bytes()
+	}
+
+	c, err := NewMultiKeyCache[int, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set(1, "value", "a1"))
+
+	// duplicate secondary key names are rejected
+	err = c.GobDecode(encode(gobCache[int, string, string, string]{SecondaryKeyNames: []string{"a", "a"}}))
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNameNotUnique[string]{})
+
+	// a secondary key shared by two primary keys is rejected
+	err = c.GobDecode(encode(gobCache[int, string, string, string]{
+		SecondaryKeyNames: []string{"a"},
+		Items: []gobItem[int, string, string, string]{
+			{PK: 2, Value: "value", SecondaryKeys: map[string]string{"a": "a2"}},
+			{PK: 3, Value: "value", SecondaryKeys: map[string]string{"a": "a2"}},
+		},
+	}))
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[int, string]{})
+
+	// a failed decode leaves the cache untouched
+	assert.Equal(t, map[int]string{1: "value"}, c.GetAll())
+}
@@ -0,0 +1,179 @@
+package multikeycache
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// loadResult is the outcome of a single loader call
+type loadResult[VT any, SKT comparable] struct {
+	value VT
+	sKeys []SKT
+	found bool
+	err   error
+}
+
+// GetWithLoad returns the value of the item with the given primary key, loading and storing it
+// with the configured loader if it is not in the cache, and a boolean indicating if the item was found.
+// It returns an error if the loader fails or the loaded item cannot be stored
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetWithLoad(pk PKT) (VT, bool, error) {
+	return c.GetContext(context.Background(), pk)
+}
+
+// GetContext works like GetWithLoad, but stops waiting for the loader and returns
+// the context error once the context is done. Items already in the cache are returned immediately
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetContext(ctx context.Context, pk PKT) (VT, bool, error) {
+	if v, ok := c.Get(pk); ok || c.loader == nil {
+		return v, ok, nil
+	}
+
+	var zero VT
+
+	// a recent miss is not loaded again
+	if c.knownMissing(pk) {
+		return zero, false, nil
+	}
+
+	// run the loader in the background so we can give up on it
+	done := make(chan loadResult[VT, SKT], 1)
+	go func() {
+		v, sKeys, found, err := c.loader(ctx, pk)
+		done <- loadResult[VT, SKT]{value: v, sKeys: sKeys, found: found, err: err}
+	}()
+
+	var res loadResult[VT, SKT]
+	select {
+	case <-ctx.Done():
+		return zero, false, ctx.Err()
+	case res = <-done:
+	}
+
+	if res.err != nil {
+		return zero, false, res.err
+	}
+
+	if !res.found {
+		c.rememberMissing(pk)
+		return zero, false, nil
+	}
+
+	return c.storeLoaded(pk, res.value, res.sKeys)
+}
+
+// minNegativeSweep is the least number of negative entries at which the expired ones are dropped
+const minNegativeSweep = 64
+
+// knownMissing returns true if the loader recently reported the item as missing
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) knownMissing(pk PKT) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiresAt, ok := c.negatives[pk]
+	return ok && c.now().Before(expiresAt)
+}
+
+// rememberMissing records that the loader reported the item as missing,
+// if negative caching is enabled
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) rememberMissing(pk PKT) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.negatives == nil {
+		c.negatives = make(map[PKT]time.Time)
+	}
+
+	// drop the expired entries now and then, so distinct misses do not pile up until Prune
+	now := c.now()
+	if len(c.negatives) >= c.negativeSweep {
+		c.pruneNegatives(now)
+		c.negativeSweep = max(2*len(c.negatives), minNegativeSweep)
+	}
+
+	c.negatives[pk] = now.Add(c.negativeTTL)
+}
+
+// storeLoaded stores a freshly loaded item and returns its value as seen by the read methods
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) storeLoaded(pk PKT, v VT, sKeys []SKT) (VT, bool, error) {
+	if err := c.Set(pk, v, sKeys...); err != nil {
+		var zero VT
+		return zero, false, err
+	}
+
+	return c.read(item[PKT, VT, SKNT, SKT]{pk: pk, value: v}), true, nil
+}
+
+// flightKey identifies a load in progress by GetOrLoadBySecondaryKey
+type flightKey[SKNT comparable, SKT comparable] struct {
+	skn SKNT
+	sk  SKT
+}
+
+// flight is a load in progress, whose result is available once done is closed.
+// waiters counts the calls that joined it, guarded by flightMu
+type flight[VT any] struct {
+	done    chan struct{}
+	value   VT
+	err     error
+	waiters int
+}
+
+// GetOrLoadBySecondaryKey returns the value of the item with the given secondary key, or on a miss
+// calls load to produce the item with its primary key and secondary keys, stores it like Set and
+// returns its value. Concurrent calls for the same secondary key share a single call to load.
+// It returns an error if the secondary key name does not exist, load fails, the item cannot be stored,
+// or ErrLoadedKeyMismatch if the item does not carry the secondary key under the name, in which
+// case it is not stored
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) GetOrLoadBySecondaryKey(skn SKNT, sk SKT, load func() (PKT, VT, []SKT, error)) (VT, error) {
+	if v, ok, err := c.GetBySecondaryKey(skn, sk); ok || err != nil {
+		return v, err
+	}
+
+	// join a load already in progress
+	key := flightKey[SKNT, SKT]{skn: skn, sk: c.normalize(skn, sk)}
+	c.flightMu.Lock()
+	if f, ok := c.flights[key]; ok {
+		f.waiters++
+		c.flightMu.Unlock()
+		<-f.done
+		return c.cloneValue(f.value), f.err
+	}
+
+	f := &flight[VT]{done: make(chan struct{})}
+	if c.flights == nil {
+		c.flights = make(map[flightKey[SKNT, SKT]]*flight[VT])
+	}
+	c.flights[key] = f
+	c.flightMu.Unlock()
+
+	// release the waiters however the load ends
+	defer func() {
+		c.flightMu.Lock()
+		delete(c.flights, key)
+		c.flightMu.Unlock()
+		close(f.done)
+	}()
+
+	pk, v, sKeys, err := load()
+	if err != nil {
+		f.err = err
+		return f.value, f.err
+	}
+
+	// the item must be found by the key it was loaded for, or every call would load it again
+	c.mu.RLock()
+	i := slices.Index(c.secondaryKeyNames, skn)
+	c.mu.RUnlock()
+	if i < 0 || i >= len(sKeys) || c.normalize(skn, sKeys[i]) != key.sk {
+		f.err = ErrLoadedKeyMismatch[PKT, SKNT, SKT]{PK: pk, SecondaryKeyName: skn, SecondaryKey: sk}
+		return f.value, f.err
+	}
+
+	// every caller gets its own copy of the value, as with any other read
+	f.value, _, f.err = c.storeLoaded(pk, v, sKeys)
+	return c.cloneValue(f.value), f.err
+}
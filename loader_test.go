@@ -0,0 +1,250 @@
+package multikeycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContext(t *testing.T) {
+	var loads atomic.Int32
+	loader := func(ctx context.Context, pk string) (string, []string, bool, error) {
+		loads.Add(1)
+		switch pk {
+		case "slow":
+			<-ctx.Done()
+			return "", nil, false, ctx.Err()
+		case "broken":
+			return "", nil, false, errors.New("broken")
+		case "missing":
+			return "", nil, false, nil
+		default:
+			return "loaded " + pk, []string{"a-" + pk}, true, nil
+		}
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithLoader[string, string, string, string](loader))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+
+	// a cache hit returns immediately, even with a cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	value, ok, err := c.GetContext(ctx, "pk1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, int32(0), loads.Load())
+
+	// a miss is loaded and stored
+	value, ok, err = c.GetContext(context.Background(), "pk2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "loaded pk2", value)
+	value, ok, err = c.GetBySecondaryKey("a", "a-pk2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "loaded pk2", value)
+
+	// the stored item is not loaded again
+	_, _, err = c.GetWithLoad("pk2")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), loads.Load())
+
+	// a slow loader is abandoned when the context is cancelled
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, ok, err = c.GetContext(ctx, "slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, ok)
+
+	// loader errors and missing items
+	_, ok, err = c.GetWithLoad("broken")
+	assert.EqualError(t, err, "broken")
+	assert.False(t, ok)
+	_, ok, err = c.GetWithLoad("missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestNegativeCache(t *testing.T) {
+	var loads atomic.Int32
+	loader := func(ctx context.Context, pk string) (string, []string, bool, error) {
+		loads.Add(1)
+		return "", nil, false, nil
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithLoader[string, string, string, string](loader),
+		WithNegativeCache[string, string, string, string](time.Minute),
+		WithClock[string, string, string, string](clock),
+	)
+	assert.Nil(t, err)
+
+	// the first miss calls the loader
+	_, ok, err := c.GetWithLoad("pk1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int32(1), loads.Load())
+
+	// repeated misses within the negative ttl do not
+	for i := 0; i < 5; i++ {
+		_, ok, err = c.GetWithLoad("pk1")
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, int32(1), loads.Load())
+
+	// negative entries are not items
+	assert.Equal(t, 0, c.Len())
+	assert.Empty(t, c.IndexEntries())
+
+	// setting the item replaces the negative entry
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	value, ok, err := c.GetWithLoad("pk1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+	c.Delete("pk1")
+	_, _, err = c.GetWithLoad("pk1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), loads.Load())
+
+	// once the negative entry expires, the loader is called again
+	clock.Advance(time.Minute)
+	_, _, err = c.GetWithLoad("pk1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), loads.Load())
+
+	// expired negative entries are dropped as new misses come in, without any prune
+	for i := 0; i < 1000; i++ {
+		_, _, err = c.GetWithLoad(fmt.Sprint("missing", i))
+		assert.Nil(t, err)
+	}
+	clock.Advance(time.Minute)
+	for i := 0; i < 1000; i++ {
+		_, _, err = c.GetWithLoad(fmt.Sprint("other", i))
+		assert.Nil(t, err)
+	}
+	assert.LessOrEqual(t, len(c.negatives), 2000)
+	for pk := range c.negatives {
+		assert.NotContains(t, pk, "missing")
+	}
+}
+
+func TestGetOrLoadBySecondaryKey(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "John", "john@example.com", "john123"))
+
+	var loads atomic.Int32
+	load := func() (string, string, []string, error) {
+		loads.Add(1)
+		return "pk2", "Jane", []string{"jane@example.com", "jane123"}, nil
+	}
+
+	// a hit does not load
+	value, err := c.GetOrLoadBySecondaryKey("email", "john@example.com", load)
+	assert.Nil(t, err)
+	assert.Equal(t, "John", value)
+	assert.Equal(t, int32(0), loads.Load())
+
+	// a miss is loaded and stored under all its keys
+	value, err = c.GetOrLoadBySecondaryKey("email", "jane@example.com", load)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", value)
+	value, ok, err := c.GetBySecondaryKey("username", "jane123")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Jane", value)
+	_, err = c.GetOrLoadBySecondaryKey("email", "jane@example.com", load)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), loads.Load())
+
+	// load errors are returned and nothing is stored
+	_, err = c.GetOrLoadBySecondaryKey("email", "jim@example.com", func() (string, string, []string, error) {
+		return "", "", nil, errors.New("broken")
+	})
+	assert.EqualError(t, err, "broken")
+	assert.Equal(t, 2, c.Len())
+
+	// so are validation errors
+	_, err = c.GetOrLoadBySecondaryKey("email", "jim@example.com", func() (string, string, []string, error) {
+		return "pk3", "Jim", []string{"jim@example.com", "john123"}, nil
+	})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+	assert.Equal(t, 2, c.Len())
+
+	// as is an item without the requested key, which would never be found by it
+	_, err = c.GetOrLoadBySecondaryKey("email", "jim@example.com", func() (string, string, []string, error) {
+		return "pk3", "Jim", []string{"james@example.com", "jim123"}, nil
+	})
+	assert.Equal(t, ErrLoadedKeyMismatch[string, string, string]{PK: "pk3", SecondaryKeyName: "email", SecondaryKey: "jim@example.com"}, err)
+	assert.ErrorIs(t, err, ErrLoadedKeyMismatchKind)
+	assert.Equal(t, 2, c.Len())
+
+	// an unknown secondary key name
+	_, err = c.GetOrLoadBySecondaryKey("phone", "555", load)
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "phone"})
+}
+
+func TestGetOrLoadBySecondaryKeyCoalescing(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email"})
+	assert.Nil(t, err)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func() (string, string, []string, error) {
+		loads.Add(1)
+		<-release
+		return "pk1", "John", []string{"john@example.com"}, nil
+	}
+
+	// start a load and wait for it to be in progress
+	results := make(chan string, 10)
+	go func() {
+		value, _ := c.GetOrLoadBySecondaryKey("email", "john@example.com", load)
+		results <- value
+	}()
+	for loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// concurrent calls join it instead of loading again
+	for i := 0; i < 9; i++ {
+		go func() {
+			value, _ := c.GetOrLoadBySecondaryKey("email", "john@example.com", load)
+			results <- value
+		}()
+	}
+	waitForWaiters(c, flightKey[string, string]{skn: "email", sk: "john@example.com"}, 9)
+	close(release)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "John", <-results)
+	}
+	assert.Equal(t, int32(1), loads.Load())
+	assert.Empty(t, c.flights)
+}
+
+// waitForWaiters blocks until n calls have joined the load in progress for the key
+func waitForWaiters[PKT comparable, VT any, SKNT comparable, SKT comparable](c *multiKeyCache[PKT, VT, SKNT, SKT], key flightKey[SKNT, SKT], n int) {
+	for {
+		c.flightMu.Lock()
+		f, ok := c.flights[key]
+		joined := ok && f.waiters >= n
+		c.flightMu.Unlock()
+		if joined {
+			return
+		}
+		runtime.Gosched()
+	}
+}
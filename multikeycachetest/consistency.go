@@ -0,0 +1,22 @@
+// Package multikeycachetest provides helpers for testing code that uses multi-key caches.
+package multikeycachetest
+
+import "testing"
+
+// Verifier is implemented by every multi-key cache
+type Verifier interface {
+	Verify() []error
+}
+
+// AssertConsistent fails the test for every discrepancy between the items
+// and the secondary indexes of the cache reported by its Verify method
+func AssertConsistent(t testing.TB, c Verifier) bool {
+	t.Helper()
+
+	errs := c.Verify()
+	for _, err := range errs {
+		t.Errorf("cache is inconsistent: %v", err)
+	}
+
+	return len(errs) == 0
+}
@@ -0,0 +1,55 @@
+package multikeycachetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kluzzebass/multikeycache"
+)
+
+// recorder is a testing.TB that records the failures instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// brokenCache reports a fixed set of discrepancies
+type brokenCache []error
+
+func (b brokenCache) Verify() []error {
+	return b
+}
+
+func TestAssertConsistent(t *testing.T) {
+	c, err := multikeycache.NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("pk1", "value", "a1", "b1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a consistent cache passes
+	r := &recorder{TB: t}
+	if !AssertConsistent(r, c) || len(r.errors) != 0 {
+		t.Errorf("expected a consistent cache to pass, got %v", r.errors)
+	}
+
+	// a broken cache fails once per discrepancy
+	r = &recorder{TB: t}
+	broken := brokenCache{errors.New("first"), errors.New("second")}
+	if AssertConsistent(r, broken) {
+		t.Error("expected a broken cache to fail")
+	}
+	expected := []string{"cache is inconsistent: first", "cache is inconsistent: second"}
+	if fmt.Sprint(r.errors) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, r.errors)
+	}
+}
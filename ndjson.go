@@ -0,0 +1,55 @@
+package multikeycache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// WriteNDJSON writes every live item to w as newline-delimited JSON, one Record per line,
+// in no particular order. Like ExportRecords it writes the stored values, not those of the read
+// transform. Each line is written as soon as it is encoded, so the cache is never copied in full,
+// but the read lock is held until all of them are written
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) WriteNDJSON(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+
+	now := c.now()
+	for _, item := range c.values {
+		if !item.live(now) {
+			continue
+		}
+
+		if err := enc.Encode(c.record(item, c.cloneValue(item.value))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadNDJSON reads records written by WriteNDJSON from r and imports them with ImportRecords.
+// If a line cannot be decoded or the records do not pass the validation of ImportRecords,
+// an error is returned and the cache is left unchanged. Unlike WriteNDJSON it does not stream:
+// all the records are decoded before any of them is set, since leaving the cache unchanged
+// means validating the whole batch first, so memory grows with the input. Use Warm with a
+// json.Decoder to insert the records as they are read, at the cost of that guarantee
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ReadNDJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	var records []Record[PKT, VT, SKNT, SKT]
+	for {
+		var record Record[PKT, VT, SKNT, SKT]
+		if err := dec.Decode(&record); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		records = append(records, record)
+	}
+
+	return c.ImportRecords(records)
+}
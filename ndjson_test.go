@@ -0,0 +1,54 @@
+package multikeycache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNDJSON(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	c, err := NewMultiKeyCache[int, user, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, c.Set(i, user{Name: fmt.Sprintf("user%d", i), Age: i % 90}, fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("user%d", i)))
+	}
+
+	// one line per item
+	var buf bytes.Buffer
+	assert.Nil(t, c.WriteNDJSON(&buf))
+	assert.Equal(t, 300, strings.Count(buf.String(), "\n"))
+
+	// the round trip restores the items and their indexes
+	d, err := NewMultiKeyCache[int, user, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.ReadNDJSON(&buf))
+	assert.Equal(t, c.GetAll(), d.GetAll())
+	assert.Empty(t, c.IndexDiff(d))
+	value, ok, err := d.GetBySecondaryKey("username", "user42")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, user{Name: "user42", Age: 42}, value)
+
+	// a conflicting line fails the whole import
+	e, err := NewMultiKeyCache[int, user, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	input := `{"pk":1,"value":{"Name":"John"},"secondaryKeys":["john@example.com","john"]}
+{"pk":2,"value":{"Name":"Jane"},"secondaryKeys":["john@example.com","jane"]}
+`
+	err = e.ReadNDJSON(strings.NewReader(input))
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[int, string]{})
+	assert.Equal(t, 0, e.Len())
+
+	// and so does a malformed line
+	err = e.ReadNDJSON(strings.NewReader(`{"pk":1,"value":{"Name":"John"},"secondaryKeys":["john@example.com","john"]}` + "\n{\n"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, e.Len())
+}
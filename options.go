@@ -0,0 +1,202 @@
+package multikeycache
+
+import (
+	"context"
+	"time"
+)
+
+// Option configures a multi-key cache when passed to NewMultiKeyCache
+type Option[PKT comparable, VT any, SKNT comparable, SKT comparable] func(*multiKeyCache[PKT, VT, SKNT, SKT])
+
+// WithReadTransform registers a function that is applied to every value returned
+// by the read methods, e.g. to produce redacted views. The stored values are not modified
+func WithReadTransform[PKT comparable, VT any, SKNT comparable, SKT comparable](transform func(pk PKT, v VT) VT) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.readTransform = transform
+	}
+}
+
+// WithOnEvict registers a hook that is called for every item the cache removes on its own,
+// together with the reason for the removal. The hook is called without holding the lock,
+// so it is safe to use the cache from within the hook
+func WithOnEvict[PKT comparable, VT any, SKNT comparable, SKT comparable](onEvict func(pk PKT, v VT, reason EvictReason)) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.onEvict = onEvict
+	}
+}
+
+// WithMaxEntries limits the number of items in the cache. When a Set would exceed the limit,
+// the least recently used items are evicted with EvictReasonCapacity. Zero means no limit
+func WithMaxEntries[PKT comparable, VT any, SKNT comparable, SKT comparable](n int) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.maxEntries = n
+	}
+}
+
+// WithMaxCost limits the total cost of the items in the cache, as computed by the function
+// given with WithCostFunc. When a Set would exceed the limit, the least recently used items
+// are evicted with EvictReasonCapacity until the total cost is within the limit again.
+// Zero means no limit
+func WithMaxCost[PKT comparable, VT any, SKNT comparable, SKT comparable](maxCost int64) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.maxCost = maxCost
+	}
+}
+
+// WithCostFunc sets the function computing the cost of a value for WithMaxCost.
+// Without it every item costs nothing
+func WithCostFunc[PKT comparable, VT any, SKNT comparable, SKT comparable](costFunc func(v VT) int64) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.costFunc = costFunc
+	}
+}
+
+// WithLoader sets the function used by GetWithLoad and GetContext to load items missing
+// from the cache. The loader returns the value with its secondary keys, in the same order as
+// the secondary key names, and a boolean indicating if the item exists at all
+func WithLoader[PKT comparable, VT any, SKNT comparable, SKT comparable](loader func(ctx context.Context, pk PKT) (v VT, sKeys []SKT, found bool, err error)) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.loader = loader
+	}
+}
+
+// WithEquality sets the function used to compare values by the value-comparing operations.
+// Without it values are compared with reflect.DeepEqual, which works for any value type
+// but is considerably slower than a comparison written for the type
+func WithEquality[PKT comparable, VT any, SKNT comparable, SKT comparable](equal func(a, b VT) bool) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.equal = equal
+	}
+}
+
+// WithNegativeCache makes GetWithLoad and GetContext remember for the given duration that the
+// loader reported an item as missing, and report it as missing without calling the loader again
+// until then. Negative entries are kept apart from the items and their secondary indexes,
+// and are dropped when the item is set, or once they have expired by Prune or as new misses
+// are remembered, so they stay within twice the number of misses within the duration
+func WithNegativeCache[PKT comparable, VT any, SKNT comparable, SKT comparable](ttl time.Duration) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.negativeTTL = ttl
+	}
+}
+
+// WithDefaultTTL makes every item set without an explicit time to live, e.g. by Set or
+// ImportRecords, expire once the given duration has passed. SetWithTTL overrides the default
+// for a single item. A duration of zero or less means the items never expire, which is the default
+func WithDefaultTTL[PKT comparable, VT any, SKNT comparable, SKT comparable](ttl time.Duration) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithObserver makes the cache report every hit, miss, stored item and eviction to the given
+// Observer, e.g. to bridge to a metrics library. The observer is called without holding the lock
+func WithObserver[PKT comparable, VT any, SKNT comparable, SKT comparable](o Observer) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.observer = o
+	}
+}
+
+// ConflictPolicy decides what happens when an item is set with a secondary key
+// that already belongs to a different live item
+type ConflictPolicy int
+
+const (
+	// ErrorOnConflict makes the set fail with ErrWrongSecondaryKey, which is the default
+	ErrorOnConflict ConflictPolicy = iota
+	// OverwriteExisting removes every item holding one of the secondary keys and stores the new item.
+	// The removed items are passed to the OnEvict hook with EvictReasonReplaced
+	OverwriteExisting
+)
+
+// WithConflictPolicy sets what Set and the other methods storing an item do when one of
+// its secondary keys belongs to a different live item
+func WithConflictPolicy[PKT comparable, VT any, SKNT comparable, SKT comparable](policy ConflictPolicy) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.conflictPolicy = policy
+	}
+}
+
+// WithRejectZeroSecondaryKeys makes Set and the other methods storing an item return
+// ErrZeroSecondaryKey when one of the secondary keys is the zero value of its type, e.g. an empty
+// string, for data where the zero value means unset and must never be indexed
+func WithRejectZeroSecondaryKeys[PKT comparable, VT any, SKNT comparable, SKT comparable]() Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.rejectZeroKeys = true
+	}
+}
+
+// WithMaxSecondaryKeyNames limits the number of secondary key names to n, e.g. when the names come
+// from configuration. NewMultiKeyCache and AddSecondaryKeyName return ErrTooManySecondaryKeyNames
+// when the limit would be exceeded. Zero or less means no limit, which is the default
+func WithMaxSecondaryKeyNames[PKT comparable, VT any, SKNT comparable, SKT comparable](n int) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.maxNames = n
+	}
+}
+
+// WithKeyNormalizer registers a normalizer for the secondary keys of the given name, e.g. to
+// lowercase email addresses. The keys are normalized when an item is stored and before every lookup
+// by that name, so keys with the same normalized form identify the same item and conflict with
+// each other. The normalized keys are the ones stored and returned, e.g. by SecondaryKeys
+func WithKeyNormalizer[PKT comparable, VT any, SKNT comparable, SKT comparable](skn SKNT, norm func(SKT) SKT) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		if c.normalizers == nil {
+			c.normalizers = make(map[SKNT]func(SKT) SKT)
+		}
+		c.normalizers[skn] = norm
+	}
+}
+
+// WithEventBuffer sets the size of the channels returned by Subscribe.
+// Events that do not fit in a subscriber's channel are dropped. Zero means the default size of 64
+func WithEventBuffer[PKT comparable, VT any, SKNT comparable, SKT comparable](size int) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.eventBuffer = size
+	}
+}
+
+// WithNoOverwrite makes Set and the other methods storing an item return ErrPrimaryKeyExists
+// instead of overwriting an item that is already in the cache. Expired items may still be replaced
+func WithNoOverwrite[PKT comparable, VT any, SKNT comparable, SKT comparable]() Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.noOverwrite = true
+	}
+}
+
+// WithInitialCapacity presizes the item map and every secondary index for n items,
+// avoiding repeated growth when the number of items is known up front, e.g. for a bulk load.
+// The maps are presized again when the cache is cleared
+func WithInitialCapacity[PKT comparable, VT any, SKNT comparable, SKT comparable](n int) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.initialCapacity = n
+	}
+}
+
+// WithValueCloner sets a function used to copy values as they enter and leave the cache,
+// so neither the caller's value passed to Set nor a value returned by the read methods
+// shares mutable state with the stored one, e.g. for pointer value types.
+// Large structs are best stored as pointers, which the cache copies cheaply, with a cloner
+// only if the callers may mutate them, since the cloner copies the whole struct on every read
+func WithValueCloner[PKT comparable, VT any, SKNT comparable, SKT comparable](clone func(v VT) VT) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.cloneFunc = clone
+	}
+}
+
+// WithClock sets the clock used to decide when items expire, instead of the system time,
+// e.g. to advance time deterministically in tests
+func WithClock[PKT comparable, VT any, SKNT comparable, SKT comparable](clock Clock) Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.clock = clock
+	}
+}
+
+// WithPartialSecondaryKeys lets Set and the other methods storing an item take fewer secondary keys
+// than there are secondary key names. The keys are matched to the names in order, and the item
+// is not indexed under the remaining names, so GetBySecondaryKey cannot find it by them
+func WithPartialSecondaryKeys[PKT comparable, VT any, SKNT comparable, SKT comparable]() Option[PKT, VT, SKNT, SKT] {
+	return func(c *multiKeyCache[PKT, VT, SKNT, SKT]) {
+		c.partialKeys = true
+	}
+}
@@ -0,0 +1,414 @@
+package multikeycache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithReadTransform(t *testing.T) {
+	redact := func(pk string, v string) string {
+		return pk + ":" + strings.Repeat("*", len(v))
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithReadTransform[string, string, string, string](redact))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "secret", "a1"))
+
+	// get returns the transformed value
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "pk1:******", value)
+
+	// so does get by secondary key
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "pk1:******", value)
+
+	// and get all
+	assert.Equal(t, map[string]string{"pk1": "pk1:******"}, c.GetAll())
+
+	// and page
+	records, _ := Page(c, 0, 10)
+	assert.Equal(t, "pk1:******", records[0].Value)
+
+	// and iterate
+	assert.Equal(t, "pk1:******", (<-c.Iterate()).Value)
+
+	// but exports keep the stored value, so that it can be imported again
+	assert.Equal(t, "secret", c.ExportRecords()[0].Value)
+
+	// the stored value is unchanged
+	assert.Equal(t, "secret", c.values["pk1"].value)
+
+	// clones keep the transform
+	value, _ = c.Clone().Get("pk1")
+	assert.Equal(t, "pk1:******", value)
+}
+
+func TestWithNoOverwrite(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithNoOverwrite[string, string, string, string](), WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+
+	// setting an existing pk is an error and leaves the item untouched
+	err = c.Set("pk1", "value2", "a2")
+	assert.ErrorAs(t, err, &ErrPrimaryKeyExists[string]{})
+	assert.Equal(t, ErrPrimaryKeyExists[string]{PK: "pk1"}, err)
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	_, ok, _ = c.GetBySecondaryKey("a", "a2")
+	assert.False(t, ok)
+
+	// the other set methods are covered as well
+	err = c.SetWithNamedKeys("pk1", "value2", map[string]string{"a": "a2"})
+	assert.ErrorAs(t, err, &ErrPrimaryKeyExists[string]{})
+
+	// a deleted pk may be set again
+	c.Delete("pk1")
+	assert.Nil(t, c.Set("pk1", "value2", "a2"))
+
+	// an expired item may be replaced
+	assert.Nil(t, c.SetWithTTL("pk2", "value1", time.Second, "b1"))
+	clock.Advance(time.Second)
+	assert.Nil(t, c.Set("pk2", "value2", "b2"))
+
+	// without the option, set overwrites as before
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", "value1", "a1"))
+	assert.Nil(t, d.Set("pk1", "value2", "a2"))
+	value, _ = d.Get("pk1")
+	assert.Equal(t, "value2", value)
+}
+
+func TestWithInitialCapacity(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"}, WithInitialCapacity[string, string, string, string](100))
+	assert.Nil(t, err)
+
+	// the presized cache works like any other
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	value, ok, err := c.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// and is still usable after clearing
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	assert.Equal(t, []string{"b1"}, c.SecondaryKeys("b"))
+}
+
+func TestWithValueCloner(t *testing.T) {
+	type user struct {
+		Name string
+	}
+	clone := func(u *user) *user {
+		c := *u
+		return &c
+	}
+
+	c, err := NewMultiKeyCache[string, *user, string, string]([]string{"a"}, WithValueCloner[string, *user, string, string](clone))
+	assert.Nil(t, err)
+
+	// mutating the value passed to set does not affect the cache
+	u := &user{Name: "John"}
+	assert.Nil(t, c.Set("pk1", u, "a1"))
+	u.Name = "Jane"
+	value, ok := c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "John", value.Name)
+
+	// neither does mutating a value returned by get
+	value.Name = "Jim"
+	value, _ = c.Get("pk1")
+	assert.Equal(t, "John", value.Name)
+
+	// or by get by secondary key
+	value, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	value.Name = "Jim"
+	value, _ = c.Get("pk1")
+	assert.Equal(t, "John", value.Name)
+
+	// or by get all
+	c.GetAll()["pk1"].Name = "Jim"
+	value, _ = c.Get("pk1")
+	assert.Equal(t, "John", value.Name)
+
+	// every subscriber gets its own copy in its events
+	events1, unsubscribe1 := c.Subscribe()
+	defer unsubscribe1()
+	events2, unsubscribe2 := c.Subscribe()
+	defer unsubscribe2()
+	assert.Nil(t, c.Set("pk2", &user{Name: "Jane"}, "a2"))
+	ev1, ev2 := <-events1, <-events2
+	ev1.Value.Name = "Jim"
+	assert.Equal(t, "Jane", ev2.Value.Name)
+	value, _ = c.Get("pk2")
+	assert.Equal(t, "Jane", value.Name)
+
+	// and so does every caller joining a load by secondary key
+	release := make(chan struct{})
+	var loads atomic.Int32
+	load := func() (string, *user, []string, error) {
+		loads.Add(1)
+		<-release
+		return "pk3", &user{Name: "Joe"}, []string{"a3"}, nil
+	}
+	results := make(chan *user, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			value, _ := c.GetOrLoadBySecondaryKey("a", "a3", load)
+			results <- value
+		}()
+	}
+	waitForWaiters(c, flightKey[string, string]{skn: "a", sk: "a3"}, 1)
+	close(release)
+	first, second := <-results, <-results
+	assert.NotSame(t, first, second)
+	first.Name = "Jim"
+	assert.Equal(t, "Joe", second.Name)
+	value, _ = c.Get("pk3")
+	assert.Equal(t, "Joe", value.Name)
+
+	// without a cloner the stored pointer is shared
+	d, err := NewMultiKeyCache[string, *user, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", u, "a1"))
+	value, _ = d.Get("pk1")
+	assert.Same(t, u, value)
+}
+
+func TestWithPartialSecondaryKeys(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"}, WithPartialSecondaryKeys[string, string, string, string]())
+	assert.Nil(t, err)
+
+	// an item with only the first secondary key
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	value, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// is not indexed under the other names, not even by the zero key
+	_, ok, err = c.GetBySecondaryKey("b", "")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, c.SecondaryKeys("c"))
+	keys, _ := c.SecondaryKeysOf("pk1")
+	assert.Equal(t, map[string]string{"a": "a1"}, keys)
+
+	// full and empty key lists are fine too
+	assert.Nil(t, c.Set("pk2", "value2", "a2", "b2", "c2"))
+	assert.Nil(t, c.Set("pk3", "value3"))
+	assert.Equal(t, 3, c.Len())
+	assert.Empty(t, c.Verify())
+
+	// conflicts are still checked on the given keys
+	err = c.Set("pk4", "value4", "a4", "b2")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+
+	// but more keys than names is still an error
+	err = c.Set("pk4", "value4", "a4", "b4", "c4", "d4")
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{})
+
+	// records only carry the keys the items have, so they round-trip through an import
+	records := c.ExportRecords()
+	assert.ElementsMatch(t, []Record[string, string, string, string]{
+		{PK: "pk1", Value: "value1", SecondaryKeys: []string{"a1"}},
+		{PK: "pk2", Value: "value2", SecondaryKeys: []string{"a2", "b2", "c2"}},
+		{PK: "pk3", Value: "value3", SecondaryKeys: []string{}},
+	}, records)
+	e, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"}, WithPartialSecondaryKeys[string, string, string, string]())
+	assert.Nil(t, err)
+	assert.Nil(t, e.ImportRecords(records))
+	assert.ElementsMatch(t, records, e.ExportRecords())
+	_, ok, err = e.GetBySecondaryKey("b", "")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, e.Verify())
+
+	// and through NDJSON
+	var buf bytes.Buffer
+	assert.Nil(t, c.WriteNDJSON(&buf))
+	e.Clear()
+	assert.Nil(t, e.ReadNDJSON(&buf))
+	assert.ElementsMatch(t, records, e.ExportRecords())
+	assert.Equal(t, []string{"c2"}, e.SecondaryKeys("c"))
+	assert.Empty(t, e.Verify())
+
+	// without the option, fewer keys is an error as before
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.ErrorAs(t, d.Set("pk1", "value1", "a1"), &ErrSecondaryKeyNumberMismatch{})
+}
+
+func TestWithConflictPolicy(t *testing.T) {
+	// the default policy fails the set
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	err = c.Set("pk2", "value2", "a1", "b2")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "a", ExistingPK: "pk1", NewPK: "pk2"}, err)
+	assert.Equal(t, 1, c.Len())
+
+	// overwriting removes the conflicting item and reports it as replaced
+	evicted := map[string]EvictReason{}
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"},
+		WithConflictPolicy[string, string, string, string](OverwriteExisting),
+		WithOnEvict[string, string, string, string](func(pk string, v string, reason EvictReason) {
+			evicted[pk] = reason
+		}),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", "value1", "a1", "b1"))
+	assert.Nil(t, d.Set("pk2", "value2", "a1", "b2"))
+	assert.Equal(t, map[string]EvictReason{"pk1": EvictReasonReplaced}, evicted)
+	assert.Equal(t, map[string]string{"pk2": "value2"}, d.GetAll())
+	_, ok, err := d.GetBySecondaryKey("b", "b1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, d.Verify())
+
+	// one item conflicting with two others replaces both of them
+	evicted = map[string]EvictReason{}
+	assert.Nil(t, d.Set("pk3", "value3", "a3", "b3"))
+	assert.Nil(t, d.Set("pk4", "value4", "a1", "b3"))
+	assert.Equal(t, map[string]EvictReason{"pk2": EvictReasonReplaced, "pk3": EvictReasonReplaced}, evicted)
+	assert.Equal(t, map[string]string{"pk4": "value4"}, d.GetAll())
+	value, ok, err := d.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value4", value)
+	assert.Empty(t, d.Verify())
+	assert.Equal(t, "Replaced", EvictReasonReplaced.String())
+}
+
+func TestWithRejectZeroSecondaryKeys(t *testing.T) {
+	// empty string secondary keys
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"}, WithRejectZeroSecondaryKeys[string, string, string, string]())
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1", "b1"))
+	err = c.Set("pk2", "value2", "a2", "")
+	assert.Equal(t, ErrZeroSecondaryKey[string]{SecondaryKeyName: "b"}, err)
+	assert.Equal(t, 1, c.Len())
+
+	// imports and remaps are checked as well
+	err = c.ImportRecords([]Record[string, string, string, string]{{PK: "pk3", Value: "value3", SecondaryKeys: []string{"", "b3"}}})
+	assert.Equal(t, ErrZeroSecondaryKey[string]{SecondaryKeyName: "a"}, err)
+	_, err = c.RemapSecondaryKeys("a", map[string]string{"a1": ""})
+	assert.Equal(t, ErrZeroSecondaryKey[string]{SecondaryKeyName: "a"}, err)
+	assert.Equal(t, []string{"a1"}, c.SecondaryKeys("a"))
+
+	// and so are new secondary key names, which are then not added
+	err = c.AddSecondaryKeyName("c", func(pk string, v string) string { return "" })
+	assert.Equal(t, ErrZeroSecondaryKey[string]{SecondaryKeyName: "c"}, err)
+	assert.Equal(t, []string{"a", "b"}, c.SecondaryKeyNames())
+	assert.Empty(t, c.Verify())
+
+	// zero int secondary keys
+	d, err := NewMultiKeyCache[string, string, string, int]([]string{"id"}, WithRejectZeroSecondaryKeys[string, string, string, int]())
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", "value1", 1))
+	assert.Nil(t, d.Set("pk2", "value2", -1))
+	err = d.Set("pk3", "value3", 0)
+	assert.Equal(t, ErrZeroSecondaryKey[string]{SecondaryKeyName: "id"}, err)
+	assert.Equal(t, 2, d.Len())
+
+	// without the option zero keys are indexed like any other
+	e, err := NewMultiKeyCache[string, string, string, int]([]string{"id"})
+	assert.Nil(t, err)
+	assert.Nil(t, e.Set("pk1", "value1", 0))
+	value, ok, err := e.GetBySecondaryKey("id", 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+}
+
+func TestWithMaxSecondaryKeyNames(t *testing.T) {
+	// exactly the maximum number of names is fine
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"}, WithMaxSecondaryKeyNames[string, string, string, string](2))
+	assert.Nil(t, err)
+
+	// adding one more exceeds it
+	err = c.AddSecondaryKeyName("c", func(pk string, v string) string { return v })
+	assert.Equal(t, ErrTooManySecondaryKeyNames{Max: 2, Actual: 3}, err)
+	assert.Equal(t, []string{"a", "b"}, c.SecondaryKeyNames())
+
+	// until a name is removed
+	assert.Nil(t, c.RemoveSecondaryKeyName("b"))
+	assert.Nil(t, c.AddSecondaryKeyName("c", func(pk string, v string) string { return v }))
+	assert.Equal(t, []string{"a", "c"}, c.SecondaryKeyNames())
+
+	// one name too many at construction
+	_, err = NewMultiKeyCache[string, string, string, string]([]string{"a", "b", "c"}, WithMaxSecondaryKeyNames[string, string, string, string](2))
+	assert.Equal(t, ErrTooManySecondaryKeyNames{Max: 2, Actual: 3}, err)
+
+	// without the option there is no limit
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = fmt.Sprint("name", i)
+	}
+	_, err = NewMultiKeyCache[string, string, string, string](names)
+	assert.Nil(t, err)
+}
+
+func TestWithKeyNormalizer(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"},
+		WithKeyNormalizer[string, string, string, string]("email", strings.ToLower),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "John", "A@X.com", "John"))
+
+	// lookups in any case resolve to the same item
+	for _, sk := range []string{"A@X.com", "a@x.com", "A@X.COM"} {
+		value, ok, err := c.GetBySecondaryKey("email", sk)
+		assert.Nil(t, err)
+		assert.True(t, ok, sk)
+		assert.Equal(t, "John", value)
+	}
+	ok, err := c.HasAnySecondaryKey("email", []string{"a@X.com"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the normalized form is stored and used for conflicts
+	assert.Equal(t, []string{"a@x.com"}, c.SecondaryKeys("email"))
+	err = c.Set("pk2", "Jane", "a@x.COM", "jane")
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "email", ExistingPK: "pk1", NewPK: "pk2"}, err)
+
+	// the other names are left alone
+	_, ok, err = c.GetBySecondaryKey("username", "john")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, c.Set("pk2", "Jane", "jane@x.com", "john"))
+
+	// remapping normalizes both the old and the new keys
+	n, err := c.RemapSecondaryKeys("email", map[string]string{"A@X.com": "B@X.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	keys, err := SortedSecondaryKeys(c, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b@x.com", "jane@x.com"}, keys)
+	value, ok, err := c.GetBySecondaryKey("email", "b@x.com")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "John", value)
+	assert.Empty(t, c.Verify())
+
+	// deleting by secondary key normalizes as well
+	assert.Nil(t, c.DeleteBySecondaryKey("email", "B@x.com"))
+	_, ok = c.Get("pk1")
+	assert.False(t, ok)
+	assert.Empty(t, c.Verify())
+}
@@ -0,0 +1,46 @@
+package multikeycache
+
+// ReadOnlyCache is a read-only view of a multi-key cache.
+// It reflects all changes made to the underlying cache, but cannot be used to make any
+type ReadOnlyCache[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	c *multiKeyCache[PKT, VT, SKNT, SKT]
+}
+
+// ReadOnly returns a read-only view of the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ReadOnly() *ReadOnlyCache[PKT, VT, SKNT, SKT] {
+	return &ReadOnlyCache[PKT, VT, SKNT, SKT]{c: c}
+}
+
+// Get returns the value of the item with the given primary key
+// and a boolean indicating if the item was found
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) Get(pk PKT) (VT, bool) {
+	return r.c.Get(pk)
+}
+
+// GetBySecondaryKey returns the value of the item with the given secondary key
+// and a boolean indicating if the item was found
+// and an error if the secondary key name does not exist
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) GetBySecondaryKey(skn SKNT, sk SKT) (VT, bool, error) {
+	return r.c.GetBySecondaryKey(skn, sk)
+}
+
+// Len returns the number of items in the cache
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) Len() int {
+	return r.c.Len()
+}
+
+// Keys returns a slice of all the primary keys in the cache
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) Keys() []PKT {
+	return r.c.Keys()
+}
+
+// SecondaryKeys returns a slice of all the secondary keys in the cache
+// for the given secondary key name
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) SecondaryKeys(skn SKNT) []SKT {
+	return r.c.SecondaryKeys(skn)
+}
+
+// GetAll returns a map of all the items in the cache
+func (r *ReadOnlyCache[PKT, VT, SKNT, SKT]) GetAll() map[PKT]VT {
+	return r.c.GetAll()
+}
@@ -0,0 +1,38 @@
+package multikeycache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+
+	r := c.ReadOnly()
+
+	// the view sees the current contents
+	value, ok := r.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	value, ok, err = r.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+
+	// and reflects later changes to the backing cache
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	c.Delete("pk1")
+	assert.Equal(t, 1, r.Len())
+	assert.Equal(t, []string{"pk2"}, r.Keys())
+	assert.Equal(t, []string{"a2"}, r.SecondaryKeys("a"))
+	assert.Equal(t, map[string]string{"pk2": "value2"}, r.GetAll())
+	_, ok = r.Get("pk1")
+	assert.False(t, ok)
+
+	// unknown secondary key names are still reported
+	_, _, err = r.GetBySecondaryKey("b", "b1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
@@ -0,0 +1,313 @@
+package multikeycache
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// Record is a flat representation of a single item, e.g. for writing to CSV or a database.
+// The secondary keys are in the same order as the secondary key names of the cache
+type Record[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	PK            PKT   `json:"pk"`
+	Value         VT    `json:"value"`
+	SecondaryKeys []SKT `json:"secondaryKeys"`
+}
+
+// ExportRecords returns a record for every live item in the cache, from the least to the most
+// recently used. The records carry the stored values, not those of the read transform, so that
+// they can be imported again. An item lacking a secondary key, e.g. after ClearIndex, has the zero value in its place,
+// unless the cache takes partial secondary keys, in which case its keys stop at the first missing one
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ExportRecords() []Record[PKT, VT, SKNT, SKT] {
+	return c.records(func(item item[PKT, VT, SKNT, SKT]) VT { return c.cloneValue(item.value) })
+}
+
+// records returns a record for every live item in the cache, from the least to the most
+// recently used, with the value produced by value for the item
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) records(value func(item item[PKT, VT, SKNT, SKT]) VT) []Record[PKT, VT, SKNT, SKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	records := make([]Record[PKT, VT, SKNT, SKT], 0, len(c.values))
+
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+
+	now := c.now()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		item := c.values[e.Value.(PKT)]
+		if !item.live(now) {
+			continue
+		}
+
+		records = append(records, c.record(item, value(item)))
+	}
+
+	return records
+}
+
+// Page returns up to limit records of the live items starting at offset, in ascending order
+// of the primary keys, together with the total number of live items. The values are returned
+// as by Get, with the read transform applied. An offset beyond the last item returns no records.
+// It is a function rather than a method since it requires an ordered primary key type
+func Page[PKT cmp.Ordered, VT any, SKNT comparable, SKT comparable](c *multiKeyCache[PKT, VT, SKNT, SKT], offset, limit int) ([]Record[PKT, VT, SKNT, SKT], int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	keys := make([]PKT, 0, len(c.values))
+	for pk, item := range c.values {
+		if item.live(now) {
+			keys = append(keys, pk)
+		}
+	}
+	slices.Sort(keys)
+
+	total := len(keys)
+	offset = max(offset, 0)
+	if offset >= total || limit <= 0 {
+		return []Record[PKT, VT, SKNT, SKT]{}, total
+	}
+	// compare against the remaining items rather than adding, as offset+limit may overflow
+	end := total
+	if limit < total-offset {
+		end = offset + limit
+	}
+	keys = keys[offset:end]
+
+	records := make([]Record[PKT, VT, SKNT, SKT], 0, len(keys))
+	for _, pk := range keys {
+		item := c.values[pk]
+		records = append(records, c.record(item, c.read(item)))
+	}
+
+	return records, total
+}
+
+// record returns the record for an item with the given value. With partial secondary keys
+// only the keys up to the first missing one are included, so that importing the record indexes
+// the item under the same names. The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) record(item item[PKT, VT, SKNT, SKT], v VT) Record[PKT, VT, SKNT, SKT] {
+	record := Record[PKT, VT, SKNT, SKT]{
+		PK:            item.pk,
+		Value:         v,
+		SecondaryKeys: make([]SKT, 0, len(c.secondaryKeyNames)),
+	}
+	for _, skn := range c.secondaryKeyNames {
+		sk, ok := item.secondaryKeys[skn]
+		if !ok && c.partialKeys {
+			break
+		}
+		record.SecondaryKeys = append(record.SecondaryKeys, sk)
+	}
+
+	return record
+}
+
+// Iterate returns a channel receiving a record for every live item in the cache, from the least
+// to the most recently used, and closed after the last one. The values are returned as by Get,
+// with the read transform applied. The records are taken at once under the read lock, so the cache
+// is not locked while they are consumed. The channel must be drained,
+// or the goroutine sending the records leaks; use IterateContext to stop early
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Iterate() <-chan Record[PKT, VT, SKNT, SKT] {
+	return c.IterateContext(context.Background())
+}
+
+// IterateContext works like Iterate, but stops sending and closes the channel
+// once the context is done
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) IterateContext(ctx context.Context) <-chan Record[PKT, VT, SKNT, SKT] {
+	records := c.records(c.read)
+	ch := make(chan Record[PKT, VT, SKNT, SKT])
+
+	go func() {
+		defer close(ch)
+
+		for _, record := range records {
+			// check first, since select picks at random when the receiver is ready too
+			if ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case ch <- record:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// ImportRecords sets an item for every record, like calling Set for each of them in order,
+// but validates all of them first: if any record has the wrong number of secondary keys,
+// or a secondary key that already exists for a different pk in the cache or in another record,
+// an error is returned and the cache is left unchanged. Under the OverwriteExisting conflict policy
+// the items in the cache holding the secondary keys are replaced instead, as with Set
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ImportRecords(records []Record[PKT, VT, SKNT, SKT]) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.now()
+	batch := make(map[SKNT]map[SKT]PKT, len(c.secondaryKeyNames))
+	seen := make(map[PKT]bool, len(records))
+	for _, r := range records {
+		// check if the secondary keys may be set
+		sKeys := c.normalizeKeys(r.SecondaryKeys)
+		if err := c.checkKeys(sKeys); err != nil {
+			return err
+		}
+
+		// check if the item may be overwritten
+		if c.noOverwrite {
+			if existing, ok := c.values[r.PK]; (ok && existing.live(now)) || seen[r.PK] {
+				return ErrPrimaryKeyExists[PKT]{PK: r.PK}
+			}
+		}
+		seen[r.PK] = true
+
+		for i, skn := range c.secondaryKeyNames[:len(sKeys)] {
+			sk := sKeys[i]
+
+			// check if the secondary key already exists for a different pk in the cache
+			if spk, ok := c.indexes[skn][sk]; ok && spk != r.PK && !c.values[spk].expired(now) && c.conflictPolicy != OverwriteExisting {
+				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: r.PK}
+			}
+
+			// or in an earlier record
+			if batch[skn] == nil {
+				batch[skn] = make(map[SKT]PKT)
+			}
+			if spk, ok := batch[skn][sk]; ok && spk != r.PK {
+				return ErrWrongSecondaryKey[PKT, SKNT]{SecondaryKey: skn, ExistingPK: spk, NewPK: r.PK}
+			}
+			batch[skn][sk] = r.PK
+		}
+	}
+
+	// set the items, which can no longer fail
+	for _, r := range records {
+		if _, _, _, err := c.set(r.PK, r.Value, c.defaultExpiry(), r.SecondaryKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplaceAll replaces the whole contents of the cache with an item for every record under a
+// single lock, so readers see either the old or the new contents but never an empty cache.
+// The records are set in order as with Set, so they must not conflict with each other, but they
+// are not checked against the items being replaced. On error the cache is left unchanged. The replaced items
+// are passed to the OnEvict hook with EvictReasonCleared, as with Clear
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ReplaceAll(records []Record[PKT, VT, SKNT, SKT]) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// build the new state in a cache of its own, which detects conflicts between the records
+	n, err := NewMultiKeyCache[PKT, VT, SKNT, SKT](c.secondaryKeyNames,
+		WithInitialCapacity[PKT, VT, SKNT, SKT](len(records)),
+		WithCostFunc[PKT, VT, SKNT, SKT](c.costFunc),
+		WithValueCloner[PKT, VT, SKNT, SKT](c.cloneFunc),
+		WithClock[PKT, VT, SKNT, SKT](c.clock),
+	)
+	if err != nil {
+		return err
+	}
+	n.noOverwrite = c.noOverwrite
+	n.partialKeys = c.partialKeys
+	n.rejectZeroKeys = c.rejectZeroKeys
+	n.normalizers = c.normalizers
+
+	for _, r := range records {
+		if _, _, _, err := n.set(r.PK, r.Value, c.defaultExpiry(), r.SecondaryKeys); err != nil {
+			return err
+		}
+	}
+
+	// queue the replaced items for the hook and the subscribers
+	for _, item := range c.values {
+		c.queueEviction(item, EvictReasonCleared)
+	}
+
+	// swap in the new state
+	c.values = n.values
+	c.indexes = n.indexes
+	c.lru = n.lru
+	c.totalCost = n.totalCost
+	c.negatives = nil
+	c.countSets(int(n.stats.sets.Load()))
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		c.emit(EventSet, c.values[e.Value.(PKT)], 0)
+	}
+
+	c.evictOverflow()
+
+	return nil
+}
+
+// warmed is an item set by Warm, together with the item it replaced, if any
+type warmed[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	pk      PKT
+	old     item[PKT, VT, SKNT, SKT]
+	existed bool
+}
+
+// Warm populates the cache from a supplier, e.g. reading a database cursor, without collecting
+// the items first. The supplier calls emit for every item, which sets it like Set and returns
+// any error to the supplier. If the supplier returns an error, the items set so far are removed
+// again, the items they overwrote under the same pk are restored where no other item has taken
+// their secondary keys, and the supplier error is returned. Items the cache removed by itself
+// during the warm are not restored, since the OnEvict hook has already been called for them:
+// those evicted for capacity, and those whose secondary keys were taken over because they had
+// expired or under the OverwriteExisting conflict policy. A supplier that wants to keep the items
+// set so far can return nil and report its error by other means
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Warm(supplier func(emit func(pk PKT, v VT, sKeys ...SKT) error) error) error {
+	var done []warmed[PKT, VT, SKNT, SKT]
+	emit := func(pk PKT, v VT, sKeys ...SKT) error {
+		c.mu.Lock()
+		defer c.unlock()
+
+		old, _, existed, err := c.set(pk, v, c.defaultExpiry(), sKeys)
+		if err != nil {
+			return err
+		}
+
+		done = append(done, warmed[PKT, VT, SKNT, SKT]{pk: pk, old: old, existed: existed})
+		return nil
+	}
+
+	err := supplier(emit)
+	if err == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	// undo the sets in reverse order, so each item is restored to its state before the warm
+	for i := len(done) - 1; i >= 0; i-- {
+		w := done[i]
+		if item, ok := c.values[w.pk]; ok {
+			c.deleteItem(item)
+		}
+
+		if w.existed && !c.keysTaken(w.old) {
+			c.store(w.old)
+		}
+	}
+
+	return err
+}
+
+// keysTaken returns true if any of the secondary keys of the item belongs to a different pk.
+// The caller must hold the lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) keysTaken(it item[PKT, VT, SKNT, SKT]) bool {
+	for skn, sk := range it.secondaryKeys {
+		if pk, ok := c.indexes[skn][sk]; ok && pk != it.pk {
+			return true
+		}
+	}
+
+	return false
+}
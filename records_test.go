@@ -0,0 +1,283 @@
+package multikeycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportRecords(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "John", "john@example.com", "john123"))
+	assert.Nil(t, c.Set("pk2", "Jane", "jane@example.com", "jane123"))
+
+	// the records match the set calls, with the keys in the order of the names
+	assert.Equal(t, []string{"email", "username"}, c.SecondaryKeyNames())
+	assert.Equal(t, []Record[string, string, string, string]{
+		{PK: "pk1", Value: "John", SecondaryKeys: []string{"john@example.com", "john123"}},
+		{PK: "pk2", Value: "Jane", SecondaryKeys: []string{"jane@example.com", "jane123"}},
+	}, c.ExportRecords())
+
+	// the records follow the recency of the items
+	_, ok := c.Get("pk1")
+	assert.True(t, ok)
+	records := c.ExportRecords()
+	assert.Equal(t, "pk2", records[0].PK)
+	assert.Equal(t, "pk1", records[1].PK)
+
+	// an empty cache exports no records
+	c.Clear()
+	assert.Empty(t, c.ExportRecords())
+}
+
+func TestIterate(t *testing.T) {
+	c, err := NewMultiKeyCache[int, string, string, int]([]string{"a"})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set(i, fmt.Sprint("value", i), i))
+	}
+
+	// every record is received in recency order, and the cache may be used meanwhile
+	var received []int
+	for record := range c.Iterate() {
+		received = append(received, record.PK)
+		c.Delete(record.PK)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, received)
+	assert.Equal(t, 0, c.Len())
+
+	// an empty cache closes the channel right away
+	_, ok := <-c.Iterate()
+	assert.False(t, ok)
+}
+
+func TestIterateContext(t *testing.T) {
+	c, err := NewMultiKeyCache[int, string, string, int]([]string{"a"})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set(i, fmt.Sprint("value", i), i))
+	}
+
+	// cancelling stops the producer, which closes the channel after at most
+	// the record it was already offering
+	ctx, cancel := context.WithCancel(context.Background())
+	records := c.IterateContext(ctx)
+	assert.Equal(t, 0, (<-records).PK)
+	cancel()
+
+	n := 0
+	for range records {
+		n++
+	}
+	assert.LessOrEqual(t, n, 1)
+}
+
+func TestImportRecords(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "John", "john@example.com", "john123"))
+	assert.Nil(t, c.Set("pk2", "Jane", "jane@example.com", "jane123"))
+
+	// a clean import restores an export
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.ImportRecords(c.ExportRecords()))
+	assert.Equal(t, c.GetAll(), d.GetAll())
+	assert.Empty(t, c.IndexDiff(d))
+
+	// a record conflicting with the cache aborts the whole import
+	err = d.ImportRecords([]Record[string, string, string, string]{
+		{PK: "pk3", Value: "Jim", SecondaryKeys: []string{"jim@example.com", "jim123"}},
+		{PK: "pk4", Value: "Joe", SecondaryKeys: []string{"joe@example.com", "john123"}},
+	})
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "username", ExistingPK: "pk1", NewPK: "pk4"}, err)
+	assert.Equal(t, 2, d.Len())
+
+	// so does a record conflicting with an earlier record
+	err = d.ImportRecords([]Record[string, string, string, string]{
+		{PK: "pk3", Value: "Jim", SecondaryKeys: []string{"jim@example.com", "jim123"}},
+		{PK: "pk4", Value: "Joe", SecondaryKeys: []string{"jim@example.com", "joe123"}},
+	})
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "email", ExistingPK: "pk3", NewPK: "pk4"}, err)
+	assert.Equal(t, 2, d.Len())
+
+	// and a record with the wrong number of secondary keys
+	err = d.ImportRecords([]Record[string, string, string, string]{
+		{PK: "pk3", Value: "Jim", SecondaryKeys: []string{"jim@example.com", "jim123"}},
+		{PK: "pk4", Value: "Joe", SecondaryKeys: []string{"joe@example.com"}},
+	})
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{})
+	assert.Equal(t, 2, d.Len())
+	_, ok := d.Get("pk3")
+	assert.False(t, ok)
+
+	// existing items are overwritten like with set
+	assert.Nil(t, d.ImportRecords([]Record[string, string, string, string]{
+		{PK: "pk1", Value: "Johnny", SecondaryKeys: []string{"john@example.com", "johnny"}},
+	}))
+	value, ok, err := d.GetBySecondaryKey("username", "johnny")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Johnny", value)
+}
+
+func TestPage(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[int, string, string, string]([]string{"a"}, WithClock[int, string, string, string](clock))
+	assert.Nil(t, err)
+	for _, pk := range []int{5, 3, 1, 4, 2} {
+		assert.Nil(t, c.Set(pk, fmt.Sprint("value", pk), fmt.Sprint("a", pk)))
+	}
+	assert.Nil(t, c.SetWithTTL(6, "value6", time.Second, "a6"))
+	clock.Advance(time.Second)
+
+	// the first page starts at the lowest pk, and the total leaves out the expired item
+	records, total := Page(c, 0, 2)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []Record[int, string, string, string]{
+		{PK: 1, Value: "value1", SecondaryKeys: []string{"a1"}},
+		{PK: 2, Value: "value2", SecondaryKeys: []string{"a2"}},
+	}, records)
+
+	// the last page is partial
+	records, total = Page(c, 4, 2)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []Record[int, string, string, string]{
+		{PK: 5, Value: "value5", SecondaryKeys: []string{"a5"}},
+	}, records)
+
+	// an offset beyond the last item returns no records, but still the total
+	records, total = Page(c, 5, 2)
+	assert.Equal(t, 5, total)
+	assert.Empty(t, records)
+	records, _ = Page(c, 100, 2)
+	assert.Empty(t, records)
+
+	// a negative offset starts at the beginning
+	records, _ = Page(c, -1, 1)
+	assert.Equal(t, 1, records[0].PK)
+
+	// a huge limit returns the rest of the items without overflowing
+	records, total = Page(c, 1, math.MaxInt)
+	assert.Equal(t, 5, total)
+	assert.Len(t, records, 4)
+	assert.Equal(t, 2, records[0].PK)
+}
+
+func TestReplaceAll(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonCleared, reason)
+		evicted = append(evicted, pk)
+	}
+
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"email", "username"}, WithOnEvict[string, string, string, string](onEvict))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "John", "john@example.com", "john123"))
+	assert.Nil(t, c.Set("pk2", "Jane", "jane@example.com", "jane123"))
+
+	// a successful replace swaps in the new contents, which may reuse the old keys
+	records := []Record[string, string, string, string]{
+		{PK: "pk3", Value: "Jim", SecondaryKeys: []string{"john@example.com", "jim123"}},
+		{PK: "pk4", Value: "Joe", SecondaryKeys: []string{"joe@example.com", "joe123"}},
+	}
+	assert.Nil(t, c.ReplaceAll(records))
+	assert.Equal(t, map[string]string{"pk3": "Jim", "pk4": "Joe"}, c.GetAll())
+	assert.ElementsMatch(t, []string{"pk1", "pk2"}, evicted)
+	value, ok, err := c.GetBySecondaryKey("email", "john@example.com")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Jim", value)
+	assert.Empty(t, c.Verify())
+
+	// a failed replace leaves the old contents intact
+	evicted = nil
+	err = c.ReplaceAll([]Record[string, string, string, string]{
+		{PK: "pk5", Value: "Jack", SecondaryKeys: []string{"jack@example.com", "jack123"}},
+		{PK: "pk6", Value: "Jill", SecondaryKeys: []string{"jack@example.com", "jill123"}},
+	})
+	assert.Equal(t, ErrWrongSecondaryKey[string, string]{SecondaryKey: "email", ExistingPK: "pk5", NewPK: "pk6"}, err)
+	err = c.ReplaceAll([]Record[string, string, string, string]{
+		{PK: "pk5", Value: "Jack", SecondaryKeys: []string{"jack@example.com"}},
+	})
+	assert.ErrorAs(t, err, &ErrSecondaryKeyNumberMismatch{})
+	assert.Equal(t, map[string]string{"pk3": "Jim", "pk4": "Joe"}, c.GetAll())
+	assert.Empty(t, evicted)
+	value, ok, err = c.GetBySecondaryKey("username", "joe123")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Joe", value)
+}
+
+func TestWarm(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "old1", "a1"))
+
+	// a successful warm sets every emitted item
+	err = c.Warm(func(emit func(pk string, v string, sKeys ...string) error) error {
+		for i := 2; i <= 4; i++ {
+			if err := emit(fmt.Sprintf("pk%d", i), fmt.Sprintf("value%d", i), fmt.Sprintf("a%d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"pk1": "old1", "pk2": "value2", "pk3": "value3", "pk4": "value4"}, c.GetAll())
+
+	// validation errors are passed to the supplier, which may carry on
+	err = c.Warm(func(emit func(pk string, v string, sKeys ...string) error) error {
+		assert.ErrorAs(t, emit("pk5", "value5", "a1"), &ErrWrongSecondaryKey[string, string]{})
+		return emit("pk5", "value5", "a5")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, c.Len())
+
+	// an aborting supplier rolls back the whole warm
+	broken := errors.New("cursor closed")
+	err = c.Warm(func(emit func(pk string, v string, sKeys ...string) error) error {
+		assert.Nil(t, emit("pk6", "value6", "a6"))
+		assert.Nil(t, emit("pk1", "new1", "a9"))
+		assert.Nil(t, emit("pk1", "newer1", "a10"))
+		return broken
+	})
+	assert.Equal(t, broken, err)
+	assert.Equal(t, map[string]string{"pk1": "old1", "pk2": "value2", "pk3": "value3", "pk4": "value4", "pk5": "value5"}, c.GetAll())
+	value, ok, err := c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "old1", value)
+	assert.Empty(t, c.Verify())
+	assert.Equal(t, 5, c.IndexStats()["a"].Entries)
+
+	// a supplier returning nil keeps the items set before its own error
+	var supplierErr error
+	err = c.Warm(func(emit func(pk string, v string, sKeys ...string) error) error {
+		assert.Nil(t, emit("pk6", "value6", "a6"))
+		supplierErr = broken
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, broken, supplierErr)
+	assert.Equal(t, 6, c.Len())
+
+	// items evicted for capacity during an aborted warm stay evicted
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithMaxEntries[string, string, string, string](1))
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", "value1", "a1"))
+	err = d.Warm(func(emit func(pk string, v string, sKeys ...string) error) error {
+		assert.Nil(t, emit("pk2", "value2", "a2"))
+		return broken
+	})
+	assert.Equal(t, broken, err)
+	assert.Equal(t, 0, d.Len())
+	assert.Empty(t, d.Verify())
+}
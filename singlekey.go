@@ -0,0 +1,62 @@
+package multikeycache
+
+// singleKeyName is the name of the only secondary key of a SingleKeyCache
+const singleKeyName = "key"
+
+// SingleKeyCache is a cache with exactly one secondary key per item,
+// so the secondary key can be given without a name
+type SingleKeyCache[PKT comparable, VT any, SKT comparable] struct {
+	c *multiKeyCache[PKT, VT, string, SKT]
+}
+
+// NewSingleKeyCache creates a new cache with a single secondary key per item.
+// The options are the same as for NewMultiKeyCache, with string as the secondary key name type
+func NewSingleKeyCache[PKT comparable, VT any, SKT comparable](opts ...Option[PKT, VT, string, SKT]) *SingleKeyCache[PKT, VT, SKT] {
+	// a single name cannot fail the uniqueness check
+	c, _ := NewMultiKeyCache[PKT, VT, string, SKT]([]string{singleKeyName}, opts...)
+	return &SingleKeyCache[PKT, VT, SKT]{c: c}
+}
+
+// Set sets the value of the item with the given primary key and secondary key
+// and returns an error if the secondary key already exists for a different primary key
+func (s *SingleKeyCache[PKT, VT, SKT]) Set(pk PKT, v VT, sk SKT) error {
+	return s.c.Set(pk, v, sk)
+}
+
+// Get returns the value of the item with the given primary key
+// and a boolean indicating if the item was found
+func (s *SingleKeyCache[PKT, VT, SKT]) Get(pk PKT) (VT, bool) {
+	return s.c.Get(pk)
+}
+
+// GetByKey returns the value of the item with the given secondary key
+// and a boolean indicating if the item was found
+func (s *SingleKeyCache[PKT, VT, SKT]) GetByKey(sk SKT) (VT, bool) {
+	v, ok, _ := s.c.GetBySecondaryKey(singleKeyName, sk)
+	return v, ok
+}
+
+// Delete deletes the item with the given primary key
+func (s *SingleKeyCache[PKT, VT, SKT]) Delete(pk PKT) {
+	s.c.Delete(pk)
+}
+
+// DeleteByKey deletes the item with the given secondary key
+func (s *SingleKeyCache[PKT, VT, SKT]) DeleteByKey(sk SKT) {
+	_ = s.c.DeleteBySecondaryKey(singleKeyName, sk)
+}
+
+// Len returns the number of items in the cache
+func (s *SingleKeyCache[PKT, VT, SKT]) Len() int {
+	return s.c.Len()
+}
+
+// Keys returns a slice of all the primary keys in the cache
+func (s *SingleKeyCache[PKT, VT, SKT]) Keys() []PKT {
+	return s.c.Keys()
+}
+
+// SecondaryKeys returns a slice of all the secondary keys in the cache
+func (s *SingleKeyCache[PKT, VT, SKT]) SecondaryKeys() []SKT {
+	return s.c.SecondaryKeys(singleKeyName)
+}
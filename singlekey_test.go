@@ -0,0 +1,47 @@
+package multikeycache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleKeyCache(t *testing.T) {
+	s := NewSingleKeyCache[string, string, string]()
+	m, err := NewMultiKeyCache[string, string, string, string]([]string{"key"})
+	assert.Nil(t, err)
+
+	// both caches are populated the same way
+	assert.Nil(t, s.Set("pk1", "value1", "a1"))
+	assert.Nil(t, m.Set("pk1", "value1", "a1"))
+	assert.Nil(t, s.Set("pk2", "value2", "a2"))
+	assert.Nil(t, m.Set("pk2", "value2", "a2"))
+
+	// and agree on lookups
+	value, ok := s.GetByKey("a2")
+	assert.True(t, ok)
+	expected, _, _ := m.GetBySecondaryKey("key", "a2")
+	assert.Equal(t, expected, value)
+	value, ok = s.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	_, ok = s.GetByKey("a3")
+	assert.False(t, ok)
+
+	// and on conflicts
+	assert.Equal(t, m.Set("pk3", "value3", "a1"), s.Set("pk3", "value3", "a1"))
+
+	// and on deletes
+	s.DeleteByKey("a1")
+	assert.Nil(t, m.DeleteBySecondaryKey("key", "a1"))
+	s.Delete("pk9")
+	assert.Equal(t, m.Len(), s.Len())
+	assert.Equal(t, m.Keys(), s.Keys())
+	assert.Equal(t, m.SecondaryKeys("key"), s.SecondaryKeys())
+
+	// options are passed through
+	o := NewSingleKeyCache[string, string, string](WithMaxEntries[string, string, string, string](1))
+	assert.Nil(t, o.Set("pk1", "value1", "a1"))
+	assert.Nil(t, o.Set("pk2", "value2", "a2"))
+	assert.Equal(t, []string{"pk2"}, o.Keys())
+}
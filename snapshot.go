@@ -0,0 +1,87 @@
+package multikeycache
+
+// Snapshot is a point-in-time copy of the live items of a cache and their secondary indexes.
+// It is not affected by later changes to the cache and is safe for concurrent use, but holds
+// a full copy of the values and indexes, so it costs as much memory as the cache it was taken from
+type Snapshot[PKT comparable, VT any, SKNT comparable, SKT comparable] struct {
+	values      map[PKT]VT
+	indexes     map[SKNT]map[SKT]PKT
+	normalizers map[SKNT]func(SKT) SKT
+}
+
+// Snapshot returns a copy of the current contents of the cache.
+// The values are copied as returned by the read methods
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Snapshot() *Snapshot[PKT, VT, SKNT, SKT] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := &Snapshot[PKT, VT, SKNT, SKT]{
+		values:      make(map[PKT]VT, len(c.values)),
+		indexes:     make(map[SKNT]map[SKT]PKT, len(c.secondaryKeyNames)),
+		normalizers: c.normalizers,
+	}
+	for _, skn := range c.secondaryKeyNames {
+		s.indexes[skn] = make(map[SKT]PKT, len(c.indexes[skn]))
+	}
+
+	now := c.now()
+	for pk, item := range c.values {
+		if !item.live(now) {
+			continue
+		}
+
+		s.values[pk] = c.read(item)
+		for skn, sk := range item.secondaryKeys {
+			if c.indexes[skn][sk] == pk {
+				s.indexes[skn][sk] = pk
+			}
+		}
+	}
+
+	return s
+}
+
+// Get returns the value of the item with the given primary key
+// and a boolean indicating if the item was found
+func (s *Snapshot[PKT, VT, SKNT, SKT]) Get(pk PKT) (VT, bool) {
+	v, ok := s.values[pk]
+	return v, ok
+}
+
+// GetBySecondaryKey returns the value of the item with the given secondary key
+// and a boolean indicating if the item was found
+// and an error if the secondary key name does not exist
+func (s *Snapshot[PKT, VT, SKNT, SKT]) GetBySecondaryKey(skn SKNT, sk SKT) (VT, bool, error) {
+	var zero VT
+
+	index, ok := s.indexes[skn]
+	if !ok {
+		return zero, false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	if norm, ok := s.normalizers[skn]; ok {
+		sk = norm(sk)
+	}
+
+	pk, ok := index[sk]
+	if !ok {
+		return zero, false, nil
+	}
+
+	v, ok := s.values[pk]
+	return v, ok, nil
+}
+
+// Len returns the number of items in the snapshot
+func (s *Snapshot[PKT, VT, SKNT, SKT]) Len() int {
+	return len(s.values)
+}
+
+// GetAll returns a map of all the items in the snapshot
+func (s *Snapshot[PKT, VT, SKNT, SKT]) GetAll() map[PKT]VT {
+	values := make(map[PKT]VT, len(s.values))
+	for pk, v := range s.values {
+		values[pk] = v
+	}
+	return values
+}
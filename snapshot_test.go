@@ -0,0 +1,45 @@
+package multikeycache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+
+	s := c.Snapshot()
+
+	// mutate the live cache in every way
+	assert.Nil(t, c.Set("pk1", "changed", "a9"))
+	c.Delete("pk2")
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	c.GetAll()["pk1"] = "mutated"
+
+	// the snapshot still shows the original contents
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, map[string]string{"pk1": "value1", "pk2": "value2"}, s.GetAll())
+	value, ok := s.Get("pk2")
+	assert.True(t, ok)
+	assert.Equal(t, "value2", value)
+	value, ok, err = s.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	_, ok, err = s.GetBySecondaryKey("a", "a3")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the map returned by get all is a copy
+	s.GetAll()["pk1"] = "mutated"
+	value, _ = s.Get("pk1")
+	assert.Equal(t, "value1", value)
+
+	// an unknown secondary key name
+	_, _, err = s.GetBySecondaryKey("b", "b1")
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+}
@@ -0,0 +1,121 @@
+package multikeycache
+
+import "sync/atomic"
+
+// Stats holds the usage statistics of a cache
+type Stats struct {
+	// Hits is the number of lookups that found an item
+	Hits uint64
+	// Misses is the number of lookups that did not find an item
+	Misses uint64
+	// Evictions is the number of items removed by the cache itself rather than by the caller
+	Evictions uint64
+	// Sets is the number of items successfully stored, including values written in place,
+	// e.g. by UpdateMany, Increment, CompareAndSwap and Mutate
+	Sets uint64
+	// DroppedEvents is the number of events not delivered because a subscriber's channel was full
+	DroppedEvents uint64
+}
+
+// stats holds the usage counters of a cache.
+// The counters are atomic so they can be updated and read without the cache lock
+type stats struct {
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	sets          atomic.Uint64
+	droppedEvents atomic.Uint64
+}
+
+// Stats returns a snapshot of the usage statistics of the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Stats() Stats {
+	return Stats{
+		Hits:          c.stats.hits.Load(),
+		Misses:        c.stats.misses.Load(),
+		Evictions:     c.stats.evictions.Load(),
+		Sets:          c.stats.sets.Load(),
+		DroppedEvents: c.stats.droppedEvents.Load(),
+	}
+}
+
+// ResetStats resets all the usage statistics of the cache to zero
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) ResetStats() {
+	c.stats.hits.Store(0)
+	c.stats.misses.Store(0)
+	c.stats.evictions.Store(0)
+	c.stats.sets.Store(0)
+	c.stats.droppedEvents.Store(0)
+}
+
+// Observer receives a call for every lookup, stored item and eviction, e.g. to push them to
+// a metrics library instead of polling Stats. The calls are made after the cache lock is released.
+// OnEvict is also called for the items removed by Clear and ReplaceAll, with EvictReasonCleared
+type Observer interface {
+	OnHit()
+	OnMiss()
+	OnSet()
+	OnEvict(reason EvictReason)
+}
+
+// lookups counts the hits and misses of a single read, to be recorded by observeLookups
+type lookups struct {
+	hits   int
+	misses int
+}
+
+// observeLookups adds the hits and misses to the statistics and reports them to the Observer.
+// It is deferred before taking the read lock, so it runs once the lock is released
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) observeLookups(l *lookups) {
+	c.stats.hits.Add(uint64(l.hits))
+	c.stats.misses.Add(uint64(l.misses))
+
+	if c.observer == nil {
+		return
+	}
+	for range l.hits {
+		c.observer.OnHit()
+	}
+	for range l.misses {
+		c.observer.OnMiss()
+	}
+}
+
+// countSets adds n stored items to the statistics and queues them for the Observer.
+// The caller must hold the write lock and release it with unlock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) countSets(n int) {
+	c.stats.sets.Add(uint64(n))
+	if c.observer != nil {
+		c.observedSets += n
+	}
+}
+
+// IndexStat holds the statistics of a single secondary index
+type IndexStat struct {
+	// Entries is the number of entries in the index
+	Entries int
+	// Balanced is true if the index has exactly one entry for every item in the cache,
+	// each pointing to an item with that secondary key
+	Balanced bool
+}
+
+// IndexStats returns a snapshot of the statistics of every secondary index, keyed by secondary key name
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) IndexStats() map[SKNT]IndexStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make(map[SKNT]IndexStat, len(c.secondaryKeyNames))
+	for _, skn := range c.secondaryKeyNames {
+		index := c.indexes[skn]
+		balanced := len(index) == len(c.values)
+		for sk, pk := range index {
+			if isk, ok := c.values[pk].secondaryKeys[skn]; !ok || isk != sk {
+				balanced = false
+				break
+			}
+		}
+
+		stats[skn] = IndexStat{Entries: len(index), Balanced: balanced}
+	}
+
+	return stats
+}
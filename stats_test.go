@@ -0,0 +1,176 @@
+package multikeycache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"})
+	assert.Nil(t, err)
+
+	// a new cache has no statistics
+	assert.Equal(t, Stats{}, c.Stats())
+
+	// two successful sets and a failed one
+	assert.Nil(t, c.Set("pk1", "value", "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+	assert.NotNil(t, c.Set("pk3", "value", "a1"))
+
+	// two hits and a miss by primary key
+	c.Get("pk1")
+	c.Get("pk2")
+	c.Get("pk3")
+
+	// a hit and two misses by secondary key
+	_, _, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	_, _, err = c.GetBySecondaryKey("a", "a3")
+	assert.Nil(t, err)
+	_, _, err = c.GetBySecondaryKey("a", "a4")
+	assert.Nil(t, err)
+
+	// an unknown secondary key name is neither a hit nor a miss
+	_, _, err = c.GetBySecondaryKey("b", "b1")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, Stats{Hits: 3, Misses: 3, Sets: 2}, c.Stats())
+
+	// reset the statistics
+	c.ResetStats()
+	assert.Equal(t, Stats{}, c.Stats())
+}
+
+func TestStatsValueWrites(t *testing.T) {
+	o := &countingObserver{}
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"}, WithObserver[string, int, string, string](o))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("pk1", 1, "a1"))
+
+	// every method writing a value counts as a set
+	assert.Equal(t, 1, c.UpdateMany(map[string]int{"pk1": 2, "pk9": 9}))
+	_, ok := Increment(c, "pk1", 1)
+	assert.True(t, ok)
+	assert.True(t, c.CompareAndSwap("pk1", 3, 4))
+	_, ok = c.Mutate("pk1", func(old int) int { return old + 1 })
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), c.Stats().Sets)
+	assert.Equal(t, 5, o.sets)
+
+	// writes that do not happen do not
+	assert.False(t, c.CompareAndSwap("pk1", 3, 4))
+	_, ok = Increment(c, "pk9", 1)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(5), c.Stats().Sets)
+
+	// neither does a reservation, but merging counts every item
+	assert.Nil(t, c.Reserve("pk2", "a2"))
+	d, err := NewMultiKeyCache[string, int, string, string]([]string{"a"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk3", 3, "a3"))
+	assert.Nil(t, c.Merge(d))
+	assert.Equal(t, uint64(6), c.Stats().Sets)
+	assert.Equal(t, 6, o.sets)
+}
+
+func TestIndexStats(t *testing.T) {
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a", "b"})
+	assert.Nil(t, err)
+
+	// a new cache has empty, balanced indexes
+	assert.Equal(t, map[string]IndexStat{"a": {Balanced: true}, "b": {Balanced: true}}, c.IndexStats())
+
+	// sets, an overwrite and a delete
+	assert.Nil(t, c.Set("pk1", "value", "a1", "b1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2", "b2"))
+	assert.Nil(t, c.Set("pk3", "value", "a3", "b3"))
+	assert.Nil(t, c.Set("pk2", "value", "a4", "b4"))
+	c.Delete("pk1")
+	assert.Equal(t, map[string]IndexStat{"a": {Entries: 2, Balanced: true}, "b": {Entries: 2, Balanced: true}}, c.IndexStats())
+
+	// a cleared index no longer matches the items
+	assert.Nil(t, c.ClearIndex("b"))
+	assert.Equal(t, map[string]IndexStat{"a": {Entries: 2, Balanced: true}, "b": {Entries: 0, Balanced: false}}, c.IndexStats())
+
+	// neither does an index with an orphaned entry
+	c.indexes["a"]["a9"] = "pk9"
+	delete(c.indexes["a"], "a3")
+	assert.Equal(t, IndexStat{Entries: 2, Balanced: false}, c.IndexStats()["a"])
+}
+
+// countingObserver is an observer counting its calls
+type countingObserver struct {
+	mu        sync.Mutex
+	hits      int
+	misses    int
+	sets      int
+	evictions map[EvictReason]int
+}
+
+func (o *countingObserver) OnHit() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.hits++
+}
+
+func (o *countingObserver) OnMiss() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.misses++
+}
+
+func (o *countingObserver) OnSet() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sets++
+}
+
+func (o *countingObserver) OnEvict(reason EvictReason) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.evictions == nil {
+		o.evictions = make(map[EvictReason]int)
+	}
+	o.evictions[reason]++
+}
+
+func TestWithObserver(t *testing.T) {
+	o := &countingObserver{}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithObserver[string, string, string, string](o),
+		WithMaxEntries[string, string, string, string](2),
+	)
+	assert.Nil(t, err)
+
+	// sets, and the eviction caused by the third one
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	assert.Nil(t, c.Set("pk2", "value2", "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	assert.Equal(t, 3, o.sets)
+	assert.Equal(t, map[EvictReason]int{EvictReasonCapacity: 1}, o.evictions)
+	assert.Equal(t, 0, o.misses)
+
+	// hits and misses by every kind of lookup
+	c.Get("pk2")
+	c.Get("pk1")
+	_, _, err = c.GetBySecondaryKey("a", "a3")
+	assert.Nil(t, err)
+	_, err = c.GetManyBySecondaryKey("a", []string{"a2", "a3", "a9"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, o.hits)
+	assert.Equal(t, 2, o.misses)
+
+	// the observer agrees with the statistics
+	stats := c.Stats()
+	assert.Equal(t, uint64(o.hits), stats.Hits)
+	assert.Equal(t, uint64(o.misses), stats.Misses)
+	assert.Equal(t, uint64(o.sets), stats.Sets)
+
+	// a failed set is not observed, and clearing reports every item
+	assert.Error(t, c.Set("pk4", "value4", "a2"))
+	c.Clear()
+	assert.Equal(t, 3, o.sets)
+	assert.Equal(t, map[EvictReason]int{EvictReasonCapacity: 1, EvictReasonCleared: 2}, o.evictions)
+}
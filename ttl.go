@@ -0,0 +1,198 @@
+package multikeycache
+
+import "time"
+
+// NoExpiration is the remaining lifetime reported by TTL for items that never expire
+const NoExpiration time.Duration = -1
+
+// Clock tells the cache the current time, which decides when items expire
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the clock used when WithClock is not given
+type realClock struct{}
+
+// Now returns the current local time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time according to the clock of the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) now() time.Time {
+	return c.clock.Now()
+}
+
+// SetWithTTL works like Set, but the item expires once the given duration has passed.
+// Expired items are no longer returned by lookups, but keep occupying the cache
+// until they are removed by Prune or their secondary keys are taken over by another item.
+// The duration overrides the default set by WithDefaultTTL, and a duration of zero or less
+// means the item never expires
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) SetWithTTL(pk PKT, v VT, ttl time.Duration, sKeys ...SKT) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	_, _, _, err := c.set(pk, v, expiresAt(c.now(), ttl), sKeys)
+	return err
+}
+
+// Touch resets the expiration of the item with the given primary key to the given duration
+// from now and returns true, or returns false if the item is absent or has already expired.
+// A duration of zero or less means the item never expires
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Touch(pk PKT, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.touchTTL(pk, ttl)
+}
+
+// TouchBySecondaryKey works like Touch for the item with the given secondary key
+// and returns an error if the secondary key name does not exist
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) TouchBySecondaryKey(skn SKNT, sk SKT, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// check if the secondary key name exists
+	if !c.secondaryKeyNameExists(skn) {
+		return false, ErrUnknownSecondaryKey[SKNT]{SecondaryKeyName: skn}
+	}
+
+	pk, ok := c.indexes[skn][c.normalize(skn, sk)]
+	if !ok {
+		return false, nil
+	}
+
+	return c.touchTTL(pk, ttl), nil
+}
+
+// RefreshWhere resets the expiration of every live item for which the predicate returns true
+// to the given duration from now, under a single lock, and returns the number of refreshed items.
+// A duration of zero or less means the items never expire.
+// The predicate is called while holding the write lock, so it must not use the cache
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) RefreshWhere(pred func(pk PKT, v VT) bool, ttl time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	n := 0
+	for pk, item := range c.values {
+		if !item.live(now) || !pred(pk, c.read(item)) {
+			continue
+		}
+
+		item.expiresAt = expiresAt(now, ttl)
+		c.values[pk] = item
+		n++
+	}
+
+	return n
+}
+
+// touchTTL resets the expiration of a live item.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) touchTTL(pk PKT, ttl time.Duration) bool {
+	now := c.now()
+	item, ok := c.values[pk]
+	if !ok || !item.live(now) {
+		return false
+	}
+
+	item.expiresAt = expiresAt(now, ttl)
+	c.values[pk] = item
+
+	return true
+}
+
+// TTL returns the remaining lifetime of the item with the given primary key, or NoExpiration
+// if the item never expires, and a boolean indicating if the item was found.
+// Expired items are reported as not found
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) TTL(pk PKT) (time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	item, ok := c.values[pk]
+	if !ok || !item.live(now) {
+		return 0, false
+	}
+
+	if item.expiresAt.IsZero() {
+		return NoExpiration, true
+	}
+
+	return item.expiresAt.Sub(now), true
+}
+
+// PendingExpired returns the number of items that have expired but have not been removed yet
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) PendingExpired() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	n := 0
+	for _, item := range c.values {
+		if item.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Prune removes all the expired items and negative cache entries from the cache
+// and returns the number of removed items.
+// If an OnEvict hook is configured, it is called for every removed item with EvictReasonExpired
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) Prune() int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.now()
+	n := 0
+	for _, item := range c.values {
+		if item.expired(now) {
+			c.evict(item, EvictReasonExpired)
+			n++
+		}
+	}
+
+	// drop the expired negative entries as well
+	c.pruneNegatives(now)
+
+	return n
+}
+
+// pruneNegatives drops the negative entries that have expired at the given time.
+// The caller must hold the write lock
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) pruneNegatives(now time.Time) {
+	for pk, expiresAt := range c.negatives {
+		if !now.Before(expiresAt) {
+			delete(c.negatives, pk)
+		}
+	}
+}
+
+// expired returns true if the item has a time to live that has passed at the given time
+func (it item[PKT, VT, SKNT, SKT]) expired(now time.Time) bool {
+	return !it.expiresAt.IsZero() && !now.Before(it.expiresAt)
+}
+
+// live returns true if the item holds a value that has not expired at the given time,
+// i.e. it is neither expired nor merely reserved
+func (it item[PKT, VT, SKNT, SKT]) live(now time.Time) bool {
+	return !it.reserved && !it.expired(now)
+}
+
+// defaultExpiry returns the expiration time for an item set without an explicit time to live,
+// which is the zero time unless WithDefaultTTL is used
+func (c *multiKeyCache[PKT, VT, SKNT, SKT]) defaultExpiry() time.Time {
+	return expiresAt(c.now(), c.defaultTTL)
+}
+
+// expiresAt returns the expiration time for the given time to live starting at now,
+// or the zero time if the time to live is zero or less
+func expiresAt(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(ttl)
+}
@@ -0,0 +1,313 @@
+package multikeycache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestPendingExpired(t *testing.T) {
+	var evicted []string
+	onEvict := func(pk string, v string, reason EvictReason) {
+		assert.Equal(t, EvictReasonExpired, reason)
+		evicted = append(evicted, pk)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithOnEvict[string, string, string, string](onEvict), WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value", time.Millisecond, "a1"))
+	assert.Nil(t, c.SetWithTTL("pk2", "value", time.Millisecond, "a2"))
+	assert.Nil(t, c.SetWithTTL("pk3", "value", time.Hour, "a3"))
+	assert.Nil(t, c.Set("pk4", "value", "a4"))
+
+	// nothing has expired yet
+	assert.Equal(t, 0, c.PendingExpired())
+
+	// let the short lived items expire
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, 2, c.PendingExpired())
+
+	// expired items are no longer returned or counted, but still occupy the cache
+	_, ok := c.Get("pk1")
+	assert.False(t, ok)
+	_, ok, err = c.GetBySecondaryKey("a", "a2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+	assert.Len(t, c.values, 4)
+
+	// pruning removes them
+	assert.Equal(t, 2, c.Prune())
+	assert.Equal(t, 0, c.PendingExpired())
+	assert.Equal(t, 2, c.Len())
+	assert.ElementsMatch(t, []string{"pk1", "pk2"}, evicted)
+	assert.Equal(t, uint64(2), c.Stats().Evictions)
+
+	// the long lived and immortal items are untouched
+	_, ok = c.Get("pk3")
+	assert.True(t, ok)
+	_, ok = c.Get("pk4")
+	assert.True(t, ok)
+}
+
+func TestExpiredSecondaryKeyTakeover(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value", time.Millisecond, "a1"))
+
+	// a live item keeps its secondary key
+	err = c.Set("pk2", "value", "a1")
+	assert.ErrorAs(t, err, &ErrWrongSecondaryKey[string, string]{})
+
+	// an expired item gives it up
+	clock.Advance(time.Millisecond)
+	assert.Nil(t, c.Set("pk2", "value", "a1"))
+	assert.Equal(t, []string{"pk2"}, c.Keys())
+	assert.Equal(t, 0, c.PendingExpired())
+}
+
+func TestTouch(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value", 20*time.Millisecond, "a1"))
+	assert.Nil(t, c.SetWithTTL("pk2", "value", 20*time.Millisecond, "a2"))
+	assert.Nil(t, c.SetWithTTL("pk3", "value", time.Millisecond, "a3"))
+	clock.Advance(5 * time.Millisecond)
+
+	// extend the lifetime by primary and secondary key
+	before := c.values["pk1"].expiresAt
+	assert.True(t, c.Touch("pk1", time.Hour))
+	assert.True(t, c.values["pk1"].expiresAt.After(before))
+	ok, err := c.TouchBySecondaryKey("a", "a2", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the touched items outlive their original ttl
+	clock.Advance(20 * time.Millisecond)
+	_, ok = c.Get("pk1")
+	assert.True(t, ok)
+	_, ok = c.Get("pk2")
+	assert.True(t, ok)
+
+	// expired and absent items cannot be touched
+	assert.False(t, c.Touch("pk3", time.Hour))
+	assert.False(t, c.Touch("pk4", time.Hour))
+	ok, err = c.TouchBySecondaryKey("a", "a4", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// an unknown secondary key name
+	ok, err = c.TouchBySecondaryKey("b", "b1", time.Hour)
+	assert.ErrorAs(t, err, &ErrUnknownSecondaryKey[string]{SecondaryKeyName: "b"})
+	assert.False(t, ok)
+}
+
+func TestRefreshWhere(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, int, string, string]([]string{"a"}, WithClock[string, int, string, string](clock))
+	assert.Nil(t, err)
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, c.SetWithTTL(fmt.Sprint("pk", i), i, time.Minute, fmt.Sprint("a", i)))
+	}
+	assert.Nil(t, c.SetWithTTL("pk5", 6, time.Second, "a5"))
+	clock.Advance(time.Second)
+
+	// only the live items matching the predicate are refreshed
+	n := c.RefreshWhere(func(pk string, v int) bool { return v%2 == 0 }, time.Hour)
+	assert.Equal(t, 2, n)
+	ttl, _ := c.TTL("pk2")
+	assert.Equal(t, time.Hour, ttl)
+	ttl, _ = c.TTL("pk1")
+	assert.Equal(t, time.Minute-time.Second, ttl)
+	_, ok := c.Get("pk5")
+	assert.False(t, ok)
+
+	// the refreshed items outlive the others
+	clock.Advance(time.Minute)
+	assert.ElementsMatch(t, []string{"pk2", "pk4"}, c.Keys())
+
+	// a zero duration makes the matching items immortal
+	assert.Equal(t, 1, c.RefreshWhere(func(pk string, v int) bool { return pk == "pk4" }, 0))
+	ttl, _ = c.TTL("pk4")
+	assert.Equal(t, NoExpiration, ttl)
+}
+
+func TestTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value", time.Hour, "a1"))
+	assert.Nil(t, c.Set("pk2", "value", "a2"))
+	assert.Nil(t, c.SetWithTTL("pk3", "value", time.Millisecond, "a3"))
+
+	// an item with a ttl
+	ttl, ok := c.TTL("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, ttl)
+
+	// an item without a ttl
+	ttl, ok = c.TTL("pk2")
+	assert.True(t, ok)
+	assert.Equal(t, NoExpiration, ttl)
+
+	// expired and absent items
+	clock.Advance(time.Millisecond)
+	_, ok = c.TTL("pk3")
+	assert.False(t, ok)
+	_, ok = c.TTL("pk4")
+	assert.False(t, ok)
+}
+
+func TestWithClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value", time.Minute, "a1"))
+
+	// the remaining lifetime follows the clock exactly
+	ttl, ok := c.TTL("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+	clock.Advance(45 * time.Second)
+	ttl, _ = c.TTL("pk1")
+	assert.Equal(t, 15*time.Second, ttl)
+
+	// the item is live until the last instant of its lifetime
+	clock.Advance(15*time.Second - time.Nanosecond)
+	_, ok = c.Get("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, 0, c.PendingExpired())
+
+	// and expires once the clock passes it, without any real time passing
+	clock.Advance(time.Nanosecond)
+	_, ok = c.Get("pk1")
+	assert.False(t, ok)
+	_, ok, err = c.GetBySecondaryKey("a", "a1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Prune())
+
+	// clones keep the clock
+	assert.Nil(t, c.SetWithTTL("pk2", "value", time.Minute, "a2"))
+	d := c.Clone()
+	clock.Advance(time.Minute)
+	_, ok = d.Get("pk2")
+	assert.False(t, ok)
+}
+
+func TestDefaultTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithClock[string, string, string, string](clock),
+		WithDefaultTTL[string, string, string, string](time.Minute),
+	)
+	assert.Nil(t, err)
+
+	// the default applies to items set without a ttl
+	assert.Nil(t, c.Set("pk1", "value1", "a1"))
+	ttl, ok := c.TTL("pk1")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+
+	// and is overridden by an explicit ttl, where zero means forever
+	assert.Nil(t, c.SetWithTTL("pk2", "value2", time.Hour, "a2"))
+	assert.Nil(t, c.SetWithTTL("pk3", "value3", 0, "a3"))
+	ttl, _ = c.TTL("pk2")
+	assert.Equal(t, time.Hour, ttl)
+	ttl, _ = c.TTL("pk3")
+	assert.Equal(t, NoExpiration, ttl)
+
+	// once the default has passed only the overridden items are left
+	clock.Advance(time.Minute)
+	_, ok = c.Get("pk1")
+	assert.False(t, ok)
+	_, ok = c.Get("pk2")
+	assert.True(t, ok)
+	_, ok = c.Get("pk3")
+	assert.True(t, ok)
+
+	// a zero default means the items never expire
+	d, err := NewMultiKeyCache[string, string, string, string]([]string{"a"},
+		WithClock[string, string, string, string](clock),
+		WithDefaultTTL[string, string, string, string](0),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("pk1", "value1", "a1"))
+	ttl, _ = d.TTL("pk1")
+	assert.Equal(t, NoExpiration, ttl)
+	clock.Advance(24 * time.Hour)
+	_, ok = d.Get("pk1")
+	assert.True(t, ok)
+}
+
+func TestLiveView(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c, err := NewMultiKeyCache[string, string, string, string]([]string{"a"}, WithClock[string, string, string, string](clock))
+	assert.Nil(t, err)
+	assert.Nil(t, c.SetWithTTL("pk1", "value1", time.Second, "a1"))
+	assert.Nil(t, c.SetWithTTL("pk2", "value2", time.Minute, "a2"))
+	assert.Nil(t, c.Set("pk3", "value3", "a3"))
+	assert.Equal(t, 3, c.Len())
+
+	// once an item expires it drops out of the listings without any lookup or prune
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, c.Len())
+	assert.ElementsMatch(t, []string{"pk2", "pk3"}, c.Keys())
+	assert.ElementsMatch(t, []string{"value2", "value3"}, c.Values())
+	assert.Equal(t, map[string]string{"pk2": "value2", "pk3": "value3"}, c.GetAll())
+	assert.ElementsMatch(t, []string{"a2", "a3"}, c.SecondaryKeys("a"))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, []string{"pk3"}, c.Keys())
+	assert.Equal(t, map[string]string{"pk3": "value3"}, c.GetAll())
+	assert.Equal(t, []string{"a3"}, c.SecondaryKeys("a"))
+
+	// the other listings skip expired and reserved items as well
+	assert.Nil(t, c.Reserve("pk4", "a4"))
+	all := func(string, string) bool { return true }
+	assert.Equal(t, map[string]string{"pk3": "value3"}, c.Filter(all))
+	assert.Equal(t, map[string]ItemView[string, string, string]{"pk3": {Value: "value3", SecondaryKeys: map[string]string{"a": "a3"}}}, c.GetAllWithKeys())
+	keys, err := c.KeysBySecondaryKeyFunc("a", func(string) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pk3"}, keys)
+
+	// the expired items are still there until pruned
+	assert.Equal(t, 2, c.PendingExpired())
+
+	// and deleting by a predicate leaves them and the reservation alone
+	assert.Equal(t, 1, c.DeleteWhere(all))
+	assert.Equal(t, 2, c.PendingExpired())
+	assert.Contains(t, c.values, "pk4")
+
+	// as does deleting by secondary key
+	n, err := c.DeleteBySecondaryKeyFunc("a", func(string) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 2, c.PendingExpired())
+	assert.Contains(t, c.values, "pk4")
+}